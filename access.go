@@ -0,0 +1,293 @@
+package main
+
+import (
+	stdjson "encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+
+	types "github.com/nedpals/hulma/node_types"
+)
+
+// UndefinedError is returned when a variable, an attribute or an index
+// cannot be resolved. Path is the full expression being evaluated and Name
+// is the part of it that does not exist.
+type UndefinedError struct {
+	Path string
+	Name string
+}
+
+func (err *UndefinedError) Error() string {
+	if err.Path == err.Name {
+		return fmt.Sprintf("variable `%s` does not exist", err.Name)
+	}
+	return fmt.Sprintf("`%s` does not exist in `%s`", err.Name, err.Path)
+}
+
+// describe returns a template-like representation of an expression node.
+// It is used for naming the full path of a failed lookup.
+func (node Node) describe() string {
+	switch types.ExpressionNodeType(node.Type) {
+	case types.NODE_TYPE_VARIABLE:
+		return node.Value
+	case types.NODE_TYPE_CONTENT:
+		return strconv.Quote(node.Value)
+	case types.NODE_TYPE_NUMBER:
+		return node.Value
+	case types.NODE_TYPE_ATTRIBUTE:
+		if len(node.Children) == 1 {
+			return node.Children[0].describe() + "." + node.Value
+		} else if len(node.Children) > 1 {
+			return node.Children[0].describe() + "." + node.Value + "(...)"
+		}
+	case types.NODE_TYPE_INDEX:
+		if len(node.Children) == 2 {
+			return node.Children[0].describe() + "[" + node.Children[1].describe() + "]"
+		}
+	case types.NODE_TYPE_SLICE:
+		if len(node.Children) != 0 {
+			bounds := []string{"", ""}
+			for _, cn := range node.Children[1:] {
+				if len(cn.Children) != 1 {
+					continue
+				} else if types.SliceNodeType(cn.Type) == types.NODE_TYPE_SLICE_START {
+					bounds[0] = cn.Children[0].describe()
+				} else if types.SliceNodeType(cn.Type) == types.NODE_TYPE_SLICE_LENGTH {
+					bounds[1] = cn.Children[0].describe()
+				}
+			}
+			return node.Children[0].describe() + "[" + strings.Join(bounds, ":") + "]"
+		}
+	}
+	return fmt.Sprintf("(%s)", node.Type)
+}
+
+func (node Node) evaluateAccess(tmpl TemplateData) (any, error) {
+	exprType := types.ExpressionNodeType(node.Type)
+	if exprType == types.NODE_TYPE_ATTRIBUTE && len(node.Children) == 0 {
+		return nil, fmt.Errorf("attribute node should have at least one child")
+	} else if exprType == types.NODE_TYPE_INDEX && len(node.Children) != 2 {
+		return nil, fmt.Errorf("index node should have exactly two children")
+	}
+
+	object, err := node.Children[0].evaluateExpression(tmpl)
+	if err != nil {
+		// report the full path instead of the part evaluated so far
+		var undefinedErr *UndefinedError
+		if errors.As(err, &undefinedErr) {
+			return nil, &UndefinedError{Path: node.describe(), Name: undefinedErr.Name}
+		}
+		return nil, err
+	} else if undefinedValue, isUndefined := object.(Undefined); isUndefined {
+		if tmpl.Undefined == UNDEFINED_CHAINABLE {
+			return Undefined{Path: node.describe(), Name: undefinedValue.Name}, nil
+		}
+		return nil, &UndefinedError{Path: node.describe(), Name: undefinedValue.Name}
+	}
+
+	// the arguments after the object make the attribute a method call
+	if exprType == types.NODE_TYPE_ATTRIBUTE && len(node.Children) > 1 {
+		return node.evaluateMethodCall(object, tmpl)
+	}
+
+	var key any = node.Value
+	if exprType == types.NODE_TYPE_INDEX {
+		key, err = node.Children[1].evaluateExpression(tmpl)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	value, found, err := lookupKey(object, key, tmpl.CallMethods && exprType == types.NODE_TYPE_ATTRIBUTE)
+	if err != nil {
+		return nil, fmt.Errorf("cannot resolve `%s`: %s", node.describe(), err.Error())
+	} else if !found {
+		return tmpl.undefined(&UndefinedError{Path: node.describe(), Name: keyString(key)})
+	}
+	return value, nil
+}
+
+func (node Node) evaluateMethodCall(object any, tmpl TemplateData) (any, error) {
+	if !tmpl.CallMethods {
+		return nil, fmt.Errorf("cannot call `%s`: calling methods is disabled", node.describe())
+	}
+
+	args, err := collectArguments(node.Children[1:], tmpl)
+	if err != nil {
+		return nil, err
+	}
+
+	value, found, err := callMethod(object, node.Value, args)
+	if err != nil {
+		return nil, fmt.Errorf("cannot call `%s`: %s", node.describe(), err.Error())
+	} else if !found {
+		return tmpl.undefined(&UndefinedError{Path: node.describe(), Name: node.Value})
+	}
+	return value, nil
+}
+
+func (node Node) evaluateSlice(tmpl TemplateData) (any, error) {
+	if len(node.Children) == 0 {
+		return nil, fmt.Errorf("slice node should have at least one child")
+	}
+
+	object, err := node.Children[0].evaluateExpression(tmpl)
+	if err != nil {
+		return nil, err
+	}
+
+	var start, length *int
+	for _, cn := range node.Children[1:] {
+		if len(cn.Children) != 1 {
+			return nil, fmt.Errorf("%s node should have exactly one child", cn.Type)
+		}
+
+		rawBound, err := cn.Children[0].evaluateExpression(tmpl)
+		if err != nil {
+			return nil, err
+		}
+
+		bound, ok := toIndex(rawBound)
+		if !ok {
+			return nil, fmt.Errorf("slice bounds of `%s` should be integers", node.describe())
+		}
+
+		switch types.SliceNodeType(cn.Type) {
+		case types.NODE_TYPE_SLICE_START:
+			start = &bound
+		case types.NODE_TYPE_SLICE_LENGTH:
+			length = &bound
+		default:
+			return nil, fmt.Errorf("invalid slice node: unexpected `%s` node", cn.Type)
+		}
+	}
+
+	return sliceValue(object, start, length)
+}
+
+// keyString converts an attribute or an index into a map key.
+func keyString(key any) string {
+	switch k := key.(type) {
+	case string:
+		return k
+	case float64:
+		return strconv.FormatFloat(k, 'f', -1, 64)
+	default:
+		return fmt.Sprintf("%v", k)
+	}
+}
+
+// toIndex converts a numeric value (or a string holding an integer) into
+// an integer index.
+func toIndex(value any) (int, bool) {
+	switch v := value.(type) {
+	case int:
+		return v, true
+	case string:
+		if idx, err := strconv.Atoi(v); err == nil {
+			return idx, true
+		}
+	case int64:
+		return int(v), true
+	case stdjson.Number:
+		if idx, err := v.Int64(); err == nil {
+			return int(idx), true
+		}
+	case float64:
+		if v == float64(int(v)) {
+			return int(v), true
+		}
+	}
+	return 0, false
+}
+
+// lookupKey resolves a single attribute or index of the value. Values other
+// than the JSON-decoded types are resolved through reflection. With
+// callMethods, zero-argument methods of Go values are called as well.
+func lookupKey(value any, key any, callMethods bool) (any, bool, error) {
+	switch object := value.(type) {
+	case nil:
+		return nil, false, nil
+	case map[string]any:
+		gotValue, exists := object[keyString(key)]
+		return gotValue, exists, nil
+	case []any:
+		idx, ok := toIndex(key)
+		if !ok {
+			return nil, false, fmt.Errorf("array index should be an integer")
+		} else if idx < 0 || idx >= len(object) {
+			return nil, false, nil
+		}
+		return object[idx], true, nil
+	case string:
+		// strings have no attributes, so `name.first` is undefined
+		idx, ok := toIndex(key)
+		runes := []rune(object)
+		if !ok || idx < 0 || idx >= len(runes) {
+			return nil, false, nil
+		}
+		return string(runes[idx]), true, nil
+	}
+
+	return lookupReflect(value, key, callMethods)
+}
+
+// sliceBounds follows Twig's slicing rules: a negative start counts from
+// the end, a missing length goes until the end and a negative length
+// leaves that many items off the end.
+func sliceBounds(size int, start, length *int) (int, int) {
+	from, to := 0, size
+	if start != nil {
+		from = *start
+		if from < 0 {
+			from += size
+		}
+	}
+	if from < 0 {
+		from = 0
+	} else if from > size {
+		from = size
+	}
+
+	if length != nil {
+		if *length < 0 {
+			to = size + *length
+		} else {
+			to = from + *length
+		}
+	}
+	if to > size {
+		to = size
+	} else if to < from {
+		to = from
+	}
+	return from, to
+}
+
+func sliceValue(value any, start, length *int) (any, error) {
+	switch object := value.(type) {
+	case nil, Undefined:
+		return nil, nil
+	case string:
+		runes := []rune(object)
+		from, to := sliceBounds(len(runes), start, length)
+		return string(runes[from:to]), nil
+	case []any:
+		from, to := sliceBounds(len(object), start, length)
+		return object[from:to], nil
+	}
+
+	rv := reflect.ValueOf(value)
+	if rv.Kind() != reflect.Slice && rv.Kind() != reflect.Array {
+		return nil, fmt.Errorf("cannot slice %T", value)
+	}
+
+	from, to := sliceBounds(rv.Len(), start, length)
+	sliced := make([]any, 0, to-from)
+	for i := from; i < to; i++ {
+		sliced = append(sliced, rv.Index(i).Interface())
+	}
+	return sliced, nil
+}
@@ -30,6 +30,13 @@ func (engs Engines) MatchEngine(rawFileName string) (Engine, string, error) {
 	return nil, "", fmt.Errorf("engine not found")
 }
 
+// TruthinessReporter is implemented by engines whose templates follow the
+// truthiness rules of their source language. The profile is named like the
+// `truthiness` field of templates.
+type TruthinessReporter interface {
+	Truthiness() string
+}
+
 type Node interface {
 	Type() nodetypes.NodeType
 	Value() string
@@ -20,9 +20,50 @@ const (
 	TWIG_RAW
 	TWIG_IDENT
 	TWIG_STRING
+	TWIG_NUMBER
+	TWIG_BOOLEAN
+	TWIG_NULL
 	TWIG_SELECTOR
+	TWIG_SUBSCRIPT
 	TWIG_FILTER
 	TWIG_CALL
+	TWIG_ARGUMENT
+	TWIG_PARAMETER
+	TWIG_DEFAULT
+	TWIG_BINARY
+	TWIG_UNARY
+	TWIG_TERNARY
+	TWIG_TEST
+	TWIG_ARRAY
+	TWIG_HASH
+	TWIG_HASH_PAIR
+	TWIG_SLICE
+	TWIG_SLICE_START
+	TWIG_SLICE_LENGTH
+	TWIG_COND
+	TWIG_COND_EXPR
+	TWIG_COND_CONSEQ
+	TWIG_COND_ALTER
+	TWIG_LOOP
+	TWIG_LOOP_TARGET
+	TWIG_LOOP_ITERABLE
+	TWIG_LOOP_BODY
+	TWIG_LOOP_ELSE
+	TWIG_ASSIGN
+	TWIG_CAPTURE
+	TWIG_ASSIGN_SCOPE
+	TWIG_BLOCK
+	TWIG_YIELD
+	TWIG_EXTENDS
+	TWIG_INCLUDE
+	TWIG_INCLUDE_NAME
+	TWIG_INCLUDE_WITH
+	TWIG_INCLUDE_ONLY
+	TWIG_INCLUDE_IGNORE_MISSING
+	TWIG_INCLUDE_OWN_BLOCKS
+	TWIG_EMBED
+	TWIG_USE
+	TWIG_PARENT
 	TWIG_COMMENT
 	TWIG_ERROR
 )
@@ -47,12 +88,94 @@ func (node TwigNode) Type() nodetypes.NodeType {
 		return nodetypes.NodeType(nodetypes.NODE_TYPE_VARIABLE)
 	case TWIG_STRING:
 		return nodetypes.NodeType(nodetypes.NODE_TYPE_CONTENT)
+	case TWIG_NUMBER:
+		return nodetypes.NodeType(nodetypes.NODE_TYPE_NUMBER)
+	case TWIG_BOOLEAN:
+		return nodetypes.NodeType(nodetypes.NODE_TYPE_BOOLEAN)
+	case TWIG_NULL:
+		return nodetypes.NodeType(nodetypes.NODE_TYPE_NULL)
 	case TWIG_SELECTOR:
-		return nodetypes.NodeType(nodetypes.NODE_TYPE_VARIABLE)
+		return nodetypes.NodeType(nodetypes.NODE_TYPE_ATTRIBUTE)
+	case TWIG_SUBSCRIPT:
+		return nodetypes.NodeType(nodetypes.NODE_TYPE_INDEX)
 	case TWIG_FILTER:
 		return nodetypes.NodeType(nodetypes.NODE_TYPE_FILTER)
 	case TWIG_CALL:
 		return nodetypes.NodeType(nodetypes.NODE_TYPE_FUNCTION)
+	case TWIG_ARGUMENT:
+		return nodetypes.NodeType(nodetypes.NODE_TYPE_FUNCTION_ARGUMENT)
+	case TWIG_PARAMETER:
+		return nodetypes.NodeType(nodetypes.NODE_TYPE_FUNCTION_PARAMETER)
+	case TWIG_DEFAULT:
+		return nodetypes.NodeType(nodetypes.NODE_TYPE_DEFAULT)
+	case TWIG_BINARY:
+		return nodetypes.NodeType(nodetypes.NODE_TYPE_BINARY)
+	case TWIG_UNARY:
+		return nodetypes.NodeType(nodetypes.NODE_TYPE_UNARY)
+	case TWIG_TERNARY:
+		return nodetypes.NodeType(nodetypes.NODE_TYPE_TERNARY)
+	case TWIG_TEST:
+		return nodetypes.NodeType(nodetypes.NODE_TYPE_TEST)
+	case TWIG_ARRAY:
+		return nodetypes.NodeType(nodetypes.NODE_TYPE_ARRAY)
+	case TWIG_HASH:
+		return nodetypes.NodeType(nodetypes.NODE_TYPE_HASH)
+	case TWIG_HASH_PAIR:
+		return nodetypes.NodeType(nodetypes.NODE_TYPE_HASH_PAIR)
+	case TWIG_SLICE:
+		return nodetypes.NodeType(nodetypes.NODE_TYPE_SLICE)
+	case TWIG_SLICE_START:
+		return nodetypes.NodeType(nodetypes.NODE_TYPE_SLICE_START)
+	case TWIG_SLICE_LENGTH:
+		return nodetypes.NodeType(nodetypes.NODE_TYPE_SLICE_LENGTH)
+	case TWIG_COND:
+		return nodetypes.NodeType(nodetypes.NODE_TYPE_COND)
+	case TWIG_COND_EXPR:
+		return nodetypes.NodeType(nodetypes.NODE_TYPE_COND_EXPR)
+	case TWIG_COND_CONSEQ:
+		return nodetypes.NodeType(nodetypes.NODE_TYPE_COND_CONSEQ)
+	case TWIG_COND_ALTER:
+		return nodetypes.NodeType(nodetypes.NODE_TYPE_COND_ALTER)
+	case TWIG_LOOP:
+		return nodetypes.NodeType(nodetypes.NODE_TYPE_LOOP)
+	case TWIG_LOOP_TARGET:
+		return nodetypes.NodeType(nodetypes.NODE_TYPE_LOOP_TARGET)
+	case TWIG_LOOP_ITERABLE:
+		return nodetypes.NodeType(nodetypes.NODE_TYPE_LOOP_ITERABLE)
+	case TWIG_LOOP_BODY:
+		return nodetypes.NodeType(nodetypes.NODE_TYPE_LOOP_BODY)
+	case TWIG_LOOP_ELSE:
+		return nodetypes.NodeType(nodetypes.NODE_TYPE_LOOP_ELSE)
+	case TWIG_ASSIGN:
+		return nodetypes.NodeType(nodetypes.NODE_TYPE_ASSIGN)
+	case TWIG_CAPTURE:
+		return nodetypes.NodeType(nodetypes.NODE_TYPE_CAPTURE)
+	case TWIG_ASSIGN_SCOPE:
+		return nodetypes.NodeType(nodetypes.NODE_TYPE_ASSIGN_SCOPE)
+	case TWIG_BLOCK:
+		return nodetypes.NODE_TYPE_BLOCK
+	case TWIG_YIELD:
+		return nodetypes.NodeType(nodetypes.NODE_TYPE_YIELD)
+	case TWIG_EXTENDS:
+		return nodetypes.NODE_TYPE_EXTENDS
+	case TWIG_INCLUDE:
+		return nodetypes.NODE_TYPE_INCLUDE
+	case TWIG_INCLUDE_NAME:
+		return nodetypes.NodeType(nodetypes.NODE_TYPE_INCLUDE_NAME)
+	case TWIG_INCLUDE_WITH:
+		return nodetypes.NodeType(nodetypes.NODE_TYPE_INCLUDE_WITH)
+	case TWIG_INCLUDE_ONLY:
+		return nodetypes.NodeType(nodetypes.NODE_TYPE_INCLUDE_ONLY)
+	case TWIG_INCLUDE_IGNORE_MISSING:
+		return nodetypes.NodeType(nodetypes.NODE_TYPE_INCLUDE_IGNORE_MISSING)
+	case TWIG_INCLUDE_OWN_BLOCKS:
+		return nodetypes.NodeType(nodetypes.NODE_TYPE_INCLUDE_OWN_BLOCKS)
+	case TWIG_EMBED:
+		return nodetypes.NODE_TYPE_EMBED
+	case TWIG_USE:
+		return nodetypes.NODE_TYPE_USE
+	case TWIG_PARENT:
+		return nodetypes.NodeType(nodetypes.NODE_TYPE_PARENT)
 	case TWIG_COMMENT:
 		return nodetypes.NodeType(nodetypes.NODE_TYPE_COMMENT)
 	default:
@@ -89,6 +212,10 @@ func (engine Twig) Render(input []byte) (Node, error) {
 	return sc.Scan()
 }
 
+func (engine Twig) Truthiness() string {
+	return "twig"
+}
+
 func (engine Twig) RenderString(input string) (Node, error) { return engine.Render([]byte(input)) }
 
 type TwigScanner struct {
@@ -109,69 +236,78 @@ func (sc TwigScanner) Scan() (TwigNode, error) {
 	sc.scanner.Mode = 0
 	sc.skipWhitespace(false)
 
-	root := TwigNode{
-		node_type: TWIG_ROOT,
-		children:  []TwigNode{},
+	children, tag, err := sc.scanBody()
+	if err != nil {
+		return sc.error(err)
+	} else if tag != nil {
+		return sc.error(sc.unexpectedTag(tag.name))
 	}
 
+	return TwigNode{
+		node_type: TWIG_ROOT,
+		children:  children,
+	}, nil
+}
+
+// scanBody scans text, display tags, comments and statements until the end
+// of the input or until a tag that belongs to an enclosing statement (such
+// as `else` or `endif`). That tag is returned with the rest of it left for
+// the caller to parse.
+func (sc TwigScanner) scanBody() ([]TwigNode, *twigTag, error) {
+	children := []TwigNode{}
+
 	for tok := sc.scanner.Scan(); tok != scanner.EOF; tok = sc.scanner.Scan() {
 		if peek := sc.scanner.Peek(); tok == '{' && (peek == '{' || peek == '%' || peek == '#') {
-			if sc.tokenBuilder.Len() != 0 {
-				root.children = append(root.children, TwigNode{
+			sc.scanner.Next()
+
+			// `{{-` and `{{~` trim the text before the tag
+			trim := sc.scanTrimMarker()
+			if text := strings.TrimRightFunc(sc.tokenBuilder.String(), twigTrimFunc(trim)); len(text) != 0 {
+				children = append(children, TwigNode{
 					node_type: TWIG_RAW,
-					value:     sc.tokenBuilder.String(),
+					value:     text,
 				})
-
-				sc.tokenBuilder.Reset()
 			}
 
-			sc.scanner.Next()
-			sc.skipWhitespace(true)
+			sc.tokenBuilder.Reset()
 
 			switch peek {
 			case '{':
-				// sc.stack.Push(TWIG_DISPLAY)
-
-				expr, err := sc.scanExpression()
+				displayNode, err := sc.scanDisplay()
 				if err != nil {
-					return expr, err
+					return nil, nil, err
 				}
 
-				finalExpr, err := sc.scanFilter(expr)
+				children = append(children, displayNode)
+			case '%':
+				tag, err := sc.scanTag()
 				if err != nil {
-					return finalExpr, err
+					return nil, nil, err
+				} else if twigClosingTags[tag.name] {
+					return children, tag, nil
 				}
 
-				tok = sc.scanner.Next()
-				if tok == '}' && sc.scanner.Peek() == '}' {
-					sc.scanner.Next()
-					root.children = append(root.children, TwigNode{
-						node_type: TWIG_DISPLAY,
-						children:  []TwigNode{finalExpr},
-					})
-				} else {
-					return sc.error(fmt.Errorf("display tag not closed (tok = %c, peek = %c)", tok, sc.scanner.Peek()))
+				stmtNodes, err := sc.scanStatement(tag)
+				if err != nil {
+					return nil, nil, err
 				}
-			case '%':
-				// sc.stack.Push(TWIG_STMT)
-				return sc.error(fmt.Errorf("statement block tag not yet implemented"))
+
+				children = append(children, stmtNodes...)
 			case '#':
 				commentNode, err := sc.scanComments()
 				if err != nil {
-					return sc.error(err)
+					return nil, nil, err
 				}
 
-				root.children = append(root.children, commentNode)
+				children = append(children, commentNode)
 			}
-
-			sc.skipWhitespace(false)
 		} else {
 			sc.tokenBuilder.WriteRune(tok)
 		}
 	}
 
 	if sc.tokenBuilder.Len() != 0 {
-		root.children = append(root.children, TwigNode{
+		children = append(children, TwigNode{
 			node_type: TWIG_RAW,
 			value:     sc.tokenBuilder.String(),
 		})
@@ -179,134 +315,154 @@ func (sc TwigScanner) Scan() (TwigNode, error) {
 		sc.tokenBuilder.Reset()
 	}
 
-	return root, nil
+	return children, nil, nil
 }
 
-func (sc TwigScanner) scanComments() (TwigNode, error) {
-	defer sc.tokenBuilder.Reset()
-	sc.skipWhitespace(false)
-
-	for {
-		tok := sc.scanner.Scan()
-		if tok == '#' && sc.scanner.Peek() == '}' {
-			sc.scanner.Next()
-			break
+// twigTrimFunc returns the characters removed by a whitespace control
+// marker. `-` removes all whitespace and `~` all of it except newlines.
+// Without a marker, nothing is removed.
+func twigTrimFunc(marker rune) func(rune) bool {
+	switch marker {
+	case '-':
+		return unicode.IsSpace
+	case '~':
+		return func(ch rune) bool {
+			return ch == ' ' || ch == '\t' || ch == '\x00' || ch == '\x0B'
 		}
-		sc.tokenBuilder.WriteRune(tok)
+	default:
+		return func(rune) bool { return false }
 	}
+}
 
-	return TwigNode{
-		node_type: TWIG_COMMENT,
-		value:     sc.tokenBuilder.String(),
-	}, nil
+// scanTrimMarker consumes the whitespace control marker after the opening
+// of a tag, if there is one.
+func (sc TwigScanner) scanTrimMarker() rune {
+	if marker := sc.scanner.Peek(); marker == '-' || marker == '~' {
+		return sc.scanner.Next()
+	}
+	return 0
 }
 
-func (sc TwigScanner) scanExpression() (TwigNode, error) {
-	tok := sc.scanner.Scan()
-	if sc.scanner.IsIdentRune(tok, 0) {
-		sc.tokenBuilder.WriteRune(tok)
-		return sc.scanExpressionFromType(sc.scanIdent(0))
-	} else {
-		switch tok {
-		case '"', '\'':
-			expected := tok
-			sc.skipWhitespace(false)
-			defer sc.tokenBuilder.Reset()
-			defer sc.skipWhitespace(true)
-
-			for {
-				tok = sc.scanner.Next()
-				if tok == expected {
-					break
-				} else if tok == scanner.EOF {
-					return sc.error(fmt.Errorf("reached eof"))
-				}
-				sc.tokenBuilder.WriteRune(tok)
-			}
+// skipTrimmed consumes the whitespace after a tag closed with a marker,
+// such as `-}}` or `~%}`.
+func (sc TwigScanner) skipTrimmed(marker rune) {
+	for trimFunc := twigTrimFunc(marker); trimFunc(sc.scanner.Peek()); {
+		sc.scanner.Next()
+	}
+}
 
-			return TwigNode{
-				node_type: TWIG_STRING,
-				value:     sc.tokenBuilder.String(),
-			}, nil
-		default:
-			return sc.error(fmt.Errorf("unknown token: %c", tok))
-		}
+// cutTrimMarker removes the whitespace control marker ending the source of
+// a tag and returns it.
+func cutTrimMarker(source string) (string, rune) {
+	if strings.HasSuffix(source, "-") || strings.HasSuffix(source, "~") {
+		return source[:len(source)-1], rune(source[len(source)-1])
 	}
+	return source, 0
 }
 
-func (sc TwigScanner) scanIdent(idx int) TwigNode {
-	tok := sc.scanner.Next()
+// scanTagSource reads the source of a tag up to its closing `}}` or `%}`.
+// Braces and strings are tracked so that a hash such as `{a: {b: 1}}` or a
+// string containing `%}` does not close the tag early. The whitespace
+// control marker before the closing, if any, is returned separately.
+func (sc TwigScanner) scanTagSource(closing rune) (string, rune, error) {
 	defer sc.tokenBuilder.Reset()
 
-	for i := idx + 1; sc.scanner.IsIdentRune(tok, i); i++ {
-		sc.tokenBuilder.WriteRune(tok)
-		tok = sc.scanner.Next()
+	// each frame is either an expression with its open braces or a string
+	// with its quote, interpolations pushing a new expression frame
+	type frame struct {
+		quote rune
+		depth int
 	}
+	frames := []frame{{}}
 
-	return TwigNode{
-		node_type: TWIG_IDENT,
-		value:     sc.tokenBuilder.String(),
-	}
-}
+	for {
+		tok := sc.scanner.Next()
+		top := &frames[len(frames)-1]
 
-func (sc TwigScanner) error(err error) (TwigNode, error) {
-	return TwigNode{node_type: TWIG_ERROR}, err
+		switch {
+		case tok == scanner.EOF:
+			if closing == '}' {
+				return "", 0, fmt.Errorf("display tag not closed")
+			}
+			return "", 0, fmt.Errorf("statement tag not closed")
+		case top.quote != 0:
+			if tok == '\\' {
+				sc.tokenBuilder.WriteRune(tok)
+				tok = sc.scanner.Next()
+			} else if tok == top.quote {
+				frames = frames[:len(frames)-1]
+			} else if tok == '#' && top.quote == '"' && sc.scanner.Peek() == '{' {
+				sc.tokenBuilder.WriteRune(tok)
+				tok = sc.scanner.Next()
+				frames = append(frames, frame{})
+			}
+		case tok == '"' || tok == '\'':
+			frames = append(frames, frame{quote: tok})
+		case tok == '{':
+			top.depth++
+		case tok == '}' && top.depth != 0:
+			top.depth--
+		case tok == '}' && len(frames) > 1:
+			frames = frames[:len(frames)-1]
+		case tok == closing && len(frames) == 1 && sc.scanner.Peek() == '}':
+			sc.scanner.Next()
+			source, trim := cutTrimMarker(sc.tokenBuilder.String())
+			return source, trim, nil
+		}
+
+		sc.tokenBuilder.WriteRune(tok)
+	}
 }
 
-func (sc TwigScanner) scanFilter(node TwigNode) (TwigNode, error) {
-	tok := sc.scanner.Peek()
+func (sc TwigScanner) scanDisplay() (TwigNode, error) {
+	source, trim, err := sc.scanTagSource('}')
+	if err != nil {
+		return sc.error(err)
+	}
 
-	if tok == '|' {
-		sc.scanner.Next()
-		identOrExpr, err := sc.scanExpression()
-		if err != nil {
-			return identOrExpr, err
-		}
+	sc.skipTrimmed(trim)
 
-		return sc.scanFilter(TwigNode{
-			node_type: TWIG_FILTER,
-			value:     identOrExpr.value,
-			children:  []TwigNode{node},
-		})
+	parser, err := newTwigParser(source)
+	if err != nil {
+		return sc.error(err)
 	}
-	return node, nil
-}
 
-func (sc TwigScanner) scanExpressionFromType(node TwigNode) (TwigNode, error) {
-	tok := sc.scanner.Peek()
-	if node.node_type == TWIG_IDENT {
-		switch tok {
-		case '(':
-			sc.scanner.Next()
-			expr, err := sc.scanExpression()
-			if err != nil {
-				return sc.error(err)
-			}
+	expr, err := parser.parseExpression()
+	if err != nil {
+		return expr, err
+	} else if err := parser.expectEnd(); err != nil {
+		return sc.error(err)
+	}
 
-			if tok = sc.scanner.Scan(); tok != '(' {
-				return sc.error(fmt.Errorf("unknown token: %c", tok))
-			}
+	return TwigNode{
+		node_type: TWIG_DISPLAY,
+		children:  []TwigNode{expr},
+	}, nil
+}
 
-			return TwigNode{
-				node_type: TWIG_CALL,
-				value:     node.value,
-				children:  []TwigNode{expr},
-			}, nil
-		case '.':
-			identOrExpr, err := sc.scanExpressionFromType(sc.scanIdent(0))
-			if err != nil {
-				return sc.error(err)
-			}
+func (sc TwigScanner) scanComments() (TwigNode, error) {
+	defer sc.tokenBuilder.Reset()
 
-			return TwigNode{
-				node_type: TWIG_SELECTOR,
-				children: []TwigNode{
-					node,
-					identOrExpr,
-				},
-			}, nil
+	for {
+		tok := sc.scanner.Scan()
+		if tok == scanner.EOF {
+			return sc.error(fmt.Errorf("comment not closed"))
+		} else if tok == '#' && sc.scanner.Peek() == '}' {
+			sc.scanner.Next()
+			break
 		}
+		sc.tokenBuilder.WriteRune(tok)
 	}
 
-	return node, nil
+	comment, trim := cutTrimMarker(sc.tokenBuilder.String())
+	sc.skipTagEnd(trim)
+
+	return TwigNode{
+		node_type: TWIG_COMMENT,
+		value:     comment,
+	}, nil
+}
+
+func (sc TwigScanner) error(err error) (TwigNode, error) {
+	return TwigNode{node_type: TWIG_ERROR}, err
 }
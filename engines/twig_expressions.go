@@ -0,0 +1,674 @@
+package engines
+
+import (
+	"fmt"
+	"strings"
+)
+
+// twigParser parses the tokens of a tag. Expressions are parsed by
+// precedence climbing with the precedences used by Twig.
+type twigParser struct {
+	tokens []twigToken
+	pos    int
+}
+
+func newTwigParser(source string) (*twigParser, error) {
+	tokens, err := lexTwig(source)
+	if err != nil {
+		return nil, err
+	}
+	return &twigParser{tokens: tokens}, nil
+}
+
+func (p *twigParser) peek() twigToken {
+	return p.peekAt(0)
+}
+
+func (p *twigParser) peekAt(offset int) twigToken {
+	if p.pos+offset >= len(p.tokens) {
+		return p.tokens[len(p.tokens)-1]
+	}
+	return p.tokens[p.pos+offset]
+}
+
+func (p *twigParser) next() twigToken {
+	tok := p.peek()
+	if p.pos < len(p.tokens)-1 {
+		p.pos++
+	}
+	return tok
+}
+
+func (p *twigParser) test(kind twigTokenType, value string) bool {
+	tok := p.peek()
+	return tok.kind == kind && tok.value == value
+}
+
+// accept consumes the token if it matches.
+func (p *twigParser) accept(kind twigTokenType, value string) bool {
+	if p.test(kind, value) {
+		p.next()
+		return true
+	}
+	return false
+}
+
+func (p *twigParser) expect(kind twigTokenType, value string) error {
+	if tok := p.next(); tok.kind != kind || tok.value != value {
+		return fmt.Errorf("expected `%s`, got %s", value, tok)
+	}
+	return nil
+}
+
+func (p *twigParser) expectName() (string, error) {
+	tok := p.next()
+	if tok.kind != TWIG_TOKEN_NAME {
+		return "", fmt.Errorf("expected a name, got %s", tok)
+	}
+	return tok.value, nil
+}
+
+func (p *twigParser) atEnd() bool {
+	return p.peek().kind == TWIG_TOKEN_EOF
+}
+
+func (p *twigParser) expectEnd() error {
+	if tok := p.peek(); tok.kind != TWIG_TOKEN_EOF {
+		return fmt.Errorf("unexpected %s, expected the end of the tag", tok)
+	}
+	return nil
+}
+
+type twigOperator struct {
+	precedence     int
+	rightAssociate bool
+}
+
+var twigBinaryOperators = map[string]twigOperator{
+	"or":          {precedence: 10},
+	"and":         {precedence: 15},
+	"b-or":        {precedence: 16},
+	"b-xor":       {precedence: 17},
+	"b-and":       {precedence: 18},
+	"==":          {precedence: 20},
+	"!=":          {precedence: 20},
+	"<":           {precedence: 20},
+	">":           {precedence: 20},
+	"<=":          {precedence: 20},
+	">=":          {precedence: 20},
+	"in":          {precedence: 20},
+	"not in":      {precedence: 20},
+	"matches":     {precedence: 20},
+	"starts with": {precedence: 20},
+	"ends with":   {precedence: 20},
+	"..":          {precedence: 25},
+	"+":           {precedence: 30},
+	"-":           {precedence: 30},
+	"~":           {precedence: 40},
+	"*":           {precedence: 60},
+	"/":           {precedence: 60},
+	"//":          {precedence: 60},
+	"%":           {precedence: 60},
+	"is":          {precedence: 100},
+	"**":          {precedence: 200, rightAssociate: true},
+	"??":          {precedence: 300, rightAssociate: true},
+}
+
+const (
+	twigNotPrecedence   = 50
+	twigUnaryPrecedence = 500
+)
+
+// twigTwoWordTests are the tests whose name is made of two words.
+var twigTwoWordTests = map[string]string{
+	"divisible": "by",
+	"same":      "as",
+}
+
+// peekOperator returns the binary operator ahead and the number of tokens
+// it is made of.
+func (p *twigParser) peekOperator() (string, int) {
+	tok := p.peek()
+	switch tok.kind {
+	case TWIG_TOKEN_PUNCT:
+		if _, ok := twigBinaryOperators[tok.value]; ok {
+			return tok.value, 1
+		}
+	case TWIG_TOKEN_NAME:
+		switch tok.value {
+		case "or", "and", "in", "is", "matches", "b-and", "b-or", "b-xor":
+			return tok.value, 1
+		case "not":
+			if next := p.peekAt(1); next.kind == TWIG_TOKEN_NAME && next.value == "in" {
+				return "not in", 2
+			}
+		case "starts", "ends":
+			if next := p.peekAt(1); next.kind == TWIG_TOKEN_NAME && next.value == "with" {
+				return tok.value + " with", 2
+			}
+		}
+	}
+	return "", 0
+}
+
+// parseExpression parses a full expression, including the conditional
+// operators `a ? b : c`, `a ? b` and `a ?: b`.
+func (p *twigParser) parseExpression() (TwigNode, error) {
+	expr, err := p.parseBinary(0)
+	if err != nil {
+		return expr, err
+	}
+
+	if p.accept(TWIG_TOKEN_PUNCT, "?") {
+		consequence, err := p.parseExpression()
+		if err != nil {
+			return consequence, err
+		}
+
+		ternaryNode := TwigNode{
+			node_type: TWIG_TERNARY,
+			children:  []TwigNode{expr, consequence},
+		}
+
+		if p.accept(TWIG_TOKEN_PUNCT, ":") {
+			alternative, err := p.parseExpression()
+			if err != nil {
+				return alternative, err
+			}
+			ternaryNode.children = append(ternaryNode.children, alternative)
+		}
+		return ternaryNode, nil
+	} else if p.accept(TWIG_TOKEN_PUNCT, "?:") {
+		alternative, err := p.parseExpression()
+		if err != nil {
+			return alternative, err
+		}
+
+		return TwigNode{
+			node_type: TWIG_BINARY,
+			value:     "?:",
+			children:  []TwigNode{expr, alternative},
+		}, nil
+	}
+
+	return expr, nil
+}
+
+// parseBinary parses the operators whose precedence is at least the given
+// one.
+func (p *twigParser) parseBinary(minPrecedence int) (TwigNode, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return left, err
+	}
+
+	for {
+		operator, size := p.peekOperator()
+		if size == 0 || twigBinaryOperators[operator].precedence < minPrecedence {
+			return left, nil
+		}
+
+		for i := 0; i < size; i++ {
+			p.next()
+		}
+
+		if operator == "is" {
+			left, err = p.parseTest(left)
+			if err != nil {
+				return left, err
+			}
+			continue
+		}
+
+		nextPrecedence := twigBinaryOperators[operator].precedence + 1
+		if twigBinaryOperators[operator].rightAssociate {
+			nextPrecedence--
+		}
+
+		right, err := p.parseBinary(nextPrecedence)
+		if err != nil {
+			return right, err
+		}
+
+		left = TwigNode{
+			node_type: TWIG_BINARY,
+			value:     operator,
+			children:  []TwigNode{left, right},
+		}
+	}
+}
+
+func (p *twigParser) parseUnary() (TwigNode, error) {
+	operator, precedence := "", 0
+	if p.test(TWIG_TOKEN_NAME, "not") {
+		operator, precedence = "not", twigNotPrecedence
+	} else if p.test(TWIG_TOKEN_PUNCT, "-") || p.test(TWIG_TOKEN_PUNCT, "+") {
+		operator, precedence = p.peek().value, twigUnaryPrecedence
+	} else {
+		return p.parsePostfix()
+	}
+
+	p.next()
+	operand, err := p.parseBinary(precedence)
+	if err != nil {
+		return operand, err
+	}
+
+	return TwigNode{
+		node_type: TWIG_UNARY,
+		value:     operator,
+		children:  []TwigNode{operand},
+	}, nil
+}
+
+// parseTest parses the test after `is` or `is not`, such as
+// `divisible by(3)`.
+func (p *twigParser) parseTest(subject TwigNode) (TwigNode, error) {
+	negated := p.accept(TWIG_TOKEN_NAME, "not")
+
+	name, err := p.expectName()
+	if err != nil {
+		return subject, err
+	} else if secondWord, ok := twigTwoWordTests[name]; ok && p.accept(TWIG_TOKEN_NAME, secondWord) {
+		name += " " + secondWord
+	}
+
+	testNode := TwigNode{
+		node_type: TWIG_TEST,
+		value:     name,
+		children:  []TwigNode{subject},
+	}
+
+	if p.accept(TWIG_TOKEN_PUNCT, "(") {
+		args, err := p.parseList(")")
+		if err != nil {
+			return subject, err
+		}
+		testNode.children = append(testNode.children, args...)
+	}
+
+	if negated {
+		return TwigNode{
+			node_type: TWIG_UNARY,
+			value:     "not",
+			children:  []TwigNode{testNode},
+		}, nil
+	}
+	return testNode, nil
+}
+
+// parseList parses comma-separated expressions until the closing
+// delimiter, allowing a trailing comma.
+func (p *twigParser) parseList(closing string) ([]TwigNode, error) {
+	items := []TwigNode{}
+	for !p.accept(TWIG_TOKEN_PUNCT, closing) {
+		if len(items) != 0 {
+			if err := p.expect(TWIG_TOKEN_PUNCT, ","); err != nil {
+				return nil, err
+			} else if p.accept(TWIG_TOKEN_PUNCT, closing) {
+				break
+			}
+		}
+
+		item, err := p.parseExpression()
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+// parseArguments parses the arguments of a call or a filter up to the
+// closing parenthesis. A named argument such as `length=30` is preceded by
+// a parameter node holding its name.
+func (p *twigParser) parseArguments() ([]TwigNode, error) {
+	args := []TwigNode{}
+	for i := 0; !p.accept(TWIG_TOKEN_PUNCT, ")"); i++ {
+		if i > 0 {
+			if err := p.expect(TWIG_TOKEN_PUNCT, ","); err != nil {
+				return nil, err
+			} else if p.accept(TWIG_TOKEN_PUNCT, ")") {
+				break
+			}
+		}
+
+		if tok, next := p.peek(), p.peekAt(1); tok.kind == TWIG_TOKEN_NAME && next.kind == TWIG_TOKEN_PUNCT && next.value == "=" {
+			p.next()
+			p.next()
+			args = append(args, TwigNode{node_type: TWIG_PARAMETER, value: tok.value})
+		}
+
+		arg, err := p.parseExpression()
+		if err != nil {
+			return nil, err
+		}
+
+		args = append(args, TwigNode{
+			node_type: TWIG_ARGUMENT,
+			children:  []TwigNode{arg},
+		})
+	}
+	return args, nil
+}
+
+// twigDefault turns `value|default(fallback)` into a default node.
+func twigDefault(filterNode TwigNode) (TwigNode, error) {
+	defaultNode := TwigNode{
+		node_type: TWIG_DEFAULT,
+		children:  []TwigNode{filterNode.children[0]},
+	}
+
+	for _, arg := range filterNode.children[1:] {
+		if arg.node_type != TWIG_ARGUMENT || len(defaultNode.children) == 2 {
+			return filterNode, fmt.Errorf("default filter expects at most one positional argument")
+		}
+		defaultNode.children = append(defaultNode.children, arg.children[0])
+	}
+	return defaultNode, nil
+}
+
+// parsePostfix parses a primary expression followed by its attributes,
+// subscripts and filters.
+func (p *twigParser) parsePostfix() (TwigNode, error) {
+	node, err := p.parsePrimary()
+	if err != nil {
+		return node, err
+	}
+
+	for {
+		switch {
+		case p.accept(TWIG_TOKEN_PUNCT, "."):
+			tok := p.next()
+			if tok.kind != TWIG_TOKEN_NAME && tok.kind != TWIG_TOKEN_NUMBER {
+				return node, fmt.Errorf("expected attribute name after `.`, got %s", tok)
+			}
+
+			node = TwigNode{
+				node_type: TWIG_SELECTOR,
+				value:     tok.value,
+				children:  []TwigNode{node},
+			}
+
+			// methods without arguments are looked up like attributes while
+			// the arguments of other calls follow the object
+			if p.accept(TWIG_TOKEN_PUNCT, "(") {
+				args, err := p.parseArguments()
+				if err != nil {
+					return node, err
+				}
+				node.children = append(node.children, args...)
+			}
+		case p.accept(TWIG_TOKEN_PUNCT, "["):
+			node, err = p.parseSubscript(node)
+			if err != nil {
+				return node, err
+			}
+		case p.accept(TWIG_TOKEN_PUNCT, "|"):
+			name, err := p.expectName()
+			if err != nil {
+				return node, err
+			}
+
+			filterNode := TwigNode{
+				node_type: TWIG_FILTER,
+				value:     name,
+				children:  []TwigNode{node},
+			}
+
+			if p.accept(TWIG_TOKEN_PUNCT, "(") {
+				args, err := p.parseArguments()
+				if err != nil {
+					return node, err
+				}
+				filterNode.children = append(filterNode.children, args...)
+			}
+
+			// the default filter also applies to undefined values, which
+			// only the default node can catch
+			if name == "default" {
+				filterNode, err = twigDefault(filterNode)
+				if err != nil {
+					return node, err
+				}
+			}
+			node = filterNode
+		default:
+			return node, nil
+		}
+	}
+}
+
+// parseSubscript parses `[key]` or the slices `[start:length]`, `[start:]`
+// and `[:length]`.
+func (p *twigParser) parseSubscript(subject TwigNode) (TwigNode, error) {
+	var start *TwigNode
+	if !p.test(TWIG_TOKEN_PUNCT, ":") {
+		key, err := p.parseExpression()
+		if err != nil {
+			return key, err
+		} else if p.accept(TWIG_TOKEN_PUNCT, "]") {
+			return TwigNode{
+				node_type: TWIG_SUBSCRIPT,
+				children:  []TwigNode{subject, key},
+			}, nil
+		}
+		start = &key
+	}
+
+	if err := p.expect(TWIG_TOKEN_PUNCT, ":"); err != nil {
+		return subject, err
+	}
+
+	sliceNode := TwigNode{
+		node_type: TWIG_SLICE,
+		children:  []TwigNode{subject},
+	}
+
+	if start != nil {
+		sliceNode.children = append(sliceNode.children, TwigNode{
+			node_type: TWIG_SLICE_START,
+			children:  []TwigNode{*start},
+		})
+	}
+
+	if !p.accept(TWIG_TOKEN_PUNCT, "]") {
+		length, err := p.parseExpression()
+		if err != nil {
+			return length, err
+		} else if err := p.expect(TWIG_TOKEN_PUNCT, "]"); err != nil {
+			return subject, err
+		}
+
+		sliceNode.children = append(sliceNode.children, TwigNode{
+			node_type: TWIG_SLICE_LENGTH,
+			children:  []TwigNode{length},
+		})
+	}
+
+	return sliceNode, nil
+}
+
+func (p *twigParser) parsePrimary() (TwigNode, error) {
+	tok := p.next()
+
+	switch tok.kind {
+	case TWIG_TOKEN_NUMBER:
+		return TwigNode{node_type: TWIG_NUMBER, value: tok.value}, nil
+	case TWIG_TOKEN_STRING:
+		return parseTwigString(tok)
+	case TWIG_TOKEN_NAME:
+		switch strings.ToLower(tok.value) {
+		case "true", "false":
+			return TwigNode{node_type: TWIG_BOOLEAN, value: strings.ToLower(tok.value)}, nil
+		case "null", "none":
+			return TwigNode{node_type: TWIG_NULL}, nil
+		}
+
+		if !p.accept(TWIG_TOKEN_PUNCT, "(") {
+			return TwigNode{node_type: TWIG_IDENT, value: tok.value}, nil
+		}
+
+		args, err := p.parseArguments()
+		if err != nil {
+			return TwigNode{}, err
+		} else if tok.value == "parent" {
+			// parent() renders the block being overridden
+			if len(args) != 0 {
+				return TwigNode{}, fmt.Errorf("parent() should be called without arguments")
+			}
+			return TwigNode{node_type: TWIG_PARENT}, nil
+		}
+
+		return TwigNode{
+			node_type: TWIG_CALL,
+			value:     tok.value,
+			children:  args,
+		}, nil
+	case TWIG_TOKEN_PUNCT:
+		switch tok.value {
+		case "(":
+			expr, err := p.parseExpression()
+			if err != nil {
+				return expr, err
+			} else if err := p.expect(TWIG_TOKEN_PUNCT, ")"); err != nil {
+				return expr, err
+			}
+			return expr, nil
+		case "[":
+			items, err := p.parseList("]")
+			if err != nil {
+				return TwigNode{}, err
+			}
+			return TwigNode{node_type: TWIG_ARRAY, children: items}, nil
+		case "{":
+			return p.parseHash()
+		}
+	}
+
+	return TwigNode{}, fmt.Errorf("unexpected %s", tok)
+}
+
+// parseHash parses `{ key: value }`. A key is a name, a string, a number,
+// or an expression in parentheses. `{ name }` is a shorthand for
+// `{ name: name }`.
+func (p *twigParser) parseHash() (TwigNode, error) {
+	hashNode := TwigNode{node_type: TWIG_HASH}
+
+	for !p.accept(TWIG_TOKEN_PUNCT, "}") {
+		if len(hashNode.children) != 0 {
+			if err := p.expect(TWIG_TOKEN_PUNCT, ","); err != nil {
+				return hashNode, err
+			} else if p.accept(TWIG_TOKEN_PUNCT, "}") {
+				break
+			}
+		}
+
+		pairNode := TwigNode{node_type: TWIG_HASH_PAIR}
+		tok := p.next()
+
+		switch {
+		case tok.kind == TWIG_TOKEN_NAME || tok.kind == TWIG_TOKEN_NUMBER:
+			pairNode.value = tok.value
+			if tok.kind == TWIG_TOKEN_NAME && (p.test(TWIG_TOKEN_PUNCT, ",") || p.test(TWIG_TOKEN_PUNCT, "}")) {
+				pairNode.children = []TwigNode{{node_type: TWIG_IDENT, value: tok.value}}
+				hashNode.children = append(hashNode.children, pairNode)
+				continue
+			}
+		case tok.kind == TWIG_TOKEN_STRING:
+			if tok.quote == '"' {
+				pairNode.value = unescapeTwigString(tok.value)
+			} else {
+				pairNode.value = tok.value
+			}
+		case tok.kind == TWIG_TOKEN_PUNCT && tok.value == "(":
+			key, err := p.parseExpression()
+			if err != nil {
+				return key, err
+			} else if err := p.expect(TWIG_TOKEN_PUNCT, ")"); err != nil {
+				return key, err
+			}
+			pairNode.children = []TwigNode{key}
+		default:
+			return hashNode, fmt.Errorf("unexpected %s, expected a hash key", tok)
+		}
+
+		if err := p.expect(TWIG_TOKEN_PUNCT, ":"); err != nil {
+			return hashNode, err
+		}
+
+		value, err := p.parseExpression()
+		if err != nil {
+			return value, err
+		}
+
+		pairNode.children = append(pairNode.children, value)
+		hashNode.children = append(hashNode.children, pairNode)
+	}
+
+	return hashNode, nil
+}
+
+// parseTwigString turns a double-quoted string with interpolations such as
+// `"Hello #{name}!"` into a concatenation of its parts.
+func parseTwigString(tok twigToken) (TwigNode, error) {
+	if tok.quote != '"' {
+		return TwigNode{node_type: TWIG_STRING, value: tok.value}, nil
+	}
+
+	parts := []TwigNode{}
+	src := []rune(tok.value)
+	literal := &strings.Builder{}
+
+	for i := 0; i < len(src); {
+		if src[i] == '\\' && i+1 < len(src) {
+			literal.WriteString(unescapeTwigString(string(src[i : i+2])))
+			i += 2
+			continue
+		} else if src[i] != '#' || i+1 == len(src) || src[i+1] != '{' {
+			literal.WriteRune(src[i])
+			i++
+			continue
+		}
+
+		end, err := skipTwigInterpolation(src, i+2)
+		if err != nil {
+			return TwigNode{}, err
+		}
+
+		parser, err := newTwigParser(string(src[i+2 : end-1]))
+		if err != nil {
+			return TwigNode{}, err
+		}
+
+		expr, err := parser.parseExpression()
+		if err != nil {
+			return expr, err
+		} else if err := parser.expectEnd(); err != nil {
+			return expr, err
+		}
+
+		// the string starts with a literal so that the result is a string
+		// even if it only has an interpolation
+		if literal.Len() != 0 || len(parts) == 0 {
+			parts = append(parts, TwigNode{node_type: TWIG_STRING, value: literal.String()})
+			literal.Reset()
+		}
+		parts = append(parts, expr)
+		i = end
+	}
+
+	if literal.Len() != 0 || len(parts) == 0 {
+		parts = append(parts, TwigNode{node_type: TWIG_STRING, value: literal.String()})
+	}
+
+	node := parts[0]
+	for _, part := range parts[1:] {
+		node = TwigNode{
+			node_type: TWIG_BINARY,
+			value:     "~",
+			children:  []TwigNode{node, part},
+		}
+	}
+	return node, nil
+}
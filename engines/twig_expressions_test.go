@@ -0,0 +1,95 @@
+package engines
+
+import (
+	"strings"
+	"testing"
+)
+
+// sexpr prints a node as `(type value children...)` using the IR types.
+func sexpr(node TwigNode) string {
+	parts := []string{string(node.Type())}
+	if len(node.value) != 0 {
+		parts = append(parts, node.value)
+	}
+	for _, cn := range node.children {
+		parts = append(parts, sexpr(cn))
+	}
+	return "(" + strings.Join(parts, " ") + ")"
+}
+
+func parseTwigExpression(source string) (TwigNode, error) {
+	p, err := newTwigParser(source)
+	if err != nil {
+		return TwigNode{}, err
+	}
+
+	node, err := p.parseExpression()
+	if err != nil {
+		return node, err
+	}
+	return node, p.expectEnd()
+}
+
+func TestParseExpression(t *testing.T) {
+	cases := []struct {
+		source   string
+		expected string
+	}{
+		{"1 + 2 * 3", "(binary + (number 1) (binary * (number 2) (number 3)))"},
+		{"(1 + 2) * 3", "(binary * (binary + (number 1) (number 2)) (number 3))"},
+		{"10 - 4 - 3", "(binary - (binary - (number 10) (number 4)) (number 3))"},
+		{"2 ** 3 ** 2", "(binary ** (number 2) (binary ** (number 3) (number 2)))"},
+		{"-2 ** 2", "(binary ** (unary - (number 2)) (number 2))"},
+		{"not a and b", "(binary and (unary not (variable a)) (variable b))"},
+		{"a or b and c", "(binary or (variable a) (binary and (variable b) (variable c)))"},
+		{"a == 1 or b", "(binary or (binary == (variable a) (number 1)) (variable b))"},
+		{"a ~ b + c", "(binary + (binary ~ (variable a) (variable b)) (variable c))"},
+		{"a ?? b ?? c", "(binary ?? (variable a) (binary ?? (variable b) (variable c)))"},
+		{"a b-or b b-xor c b-and d", "(binary b-or (variable a) (binary b-xor (variable b) (binary b-and (variable c) (variable d))))"},
+		{"a not in b", "(binary not in (variable a) (variable b))"},
+		{"a starts with 'x' and b ends with 'y'", "(binary and (binary starts with (variable a) (content x)) (binary ends with (variable b) (content y)))"},
+		{"a matches '/x/'", "(binary matches (variable a) (content /x/))"},
+		{"1..5", "(binary .. (number 1) (number 5))"},
+		{"1e3", "(number 1e3)"},
+		{"a is divisible by(3)", "(test divisible by (variable a) (number 3))"},
+		{"a is not null", "(unary not (test null (variable a)))"},
+		{"x ? y : z", "(ternary (variable x) (variable y) (variable z))"},
+		{"x ? y", "(ternary (variable x) (variable y))"},
+		{"x ?: z", "(binary ?: (variable x) (variable z))"},
+		{"user.name()", "(attribute name (variable user))"},
+		{"user.format('Y', n=2)|upper", "(filter upper (attribute format (variable user) (filter_argument (content Y)) (filter_parameter n) (filter_argument (number 2))))"},
+		{"fn(a)", "(function fn (filter_argument (variable a)))"},
+		{"a|default('x')", "(default (variable a) (content x))"},
+		{"items[1:2]", "(slice (variable items) (slice_start (number 1)) (slice_length (number 2)))"},
+		{"items[:2]", "(slice (variable items) (slice_length (number 2)))"},
+		{"[1, 'two']", "(array (number 1) (content two))"},
+		{"{a: 1, (b): 2, c}", "(hash (hash_pair a (number 1)) (hash_pair (variable b) (number 2)) (hash_pair c (variable c)))"},
+		{`"Hi #{name}"`, "(binary ~ (content Hi ) (variable name))"},
+	}
+
+	for _, c := range cases {
+		node, err := parseTwigExpression(c.source)
+		if err != nil {
+			t.Errorf("%q: unexpected error: %s", c.source, err)
+		} else if got := sexpr(node); got != c.expected {
+			t.Errorf("%q: expected %s, got %s", c.source, c.expected, got)
+		}
+	}
+}
+
+func TestParseExpressionErrors(t *testing.T) {
+	cases := map[string]string{
+		"1 +":      "unexpected end of tag",
+		"(1":       "expected `)`, got end of tag",
+		"a b":      "unexpected `b`, expected the end of the tag",
+		"a.":       "expected attribute name after `.`, got end of tag",
+		"a[1:2:3]": "expected `]`, got `:`",
+		"{1 2}":    "expected `:`, got `2`",
+	}
+
+	for source, expected := range cases {
+		if _, err := parseTwigExpression(source); err == nil || err.Error() != expected {
+			t.Errorf("%q: expected error %q, got %v", source, expected, err)
+		}
+	}
+}
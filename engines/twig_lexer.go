@@ -0,0 +1,217 @@
+package engines
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+type twigTokenType int
+
+const (
+	TWIG_TOKEN_NAME twigTokenType = iota
+	TWIG_TOKEN_NUMBER
+	TWIG_TOKEN_STRING
+	TWIG_TOKEN_PUNCT
+	TWIG_TOKEN_EOF
+)
+
+// twigToken is a token inside a tag. Single-quoted strings hold their
+// unescaped value while double-quoted strings keep their source, since
+// they may contain `#{...}` interpolations.
+type twigToken struct {
+	kind  twigTokenType
+	value string
+	quote rune
+}
+
+func (tok twigToken) String() string {
+	switch tok.kind {
+	case TWIG_TOKEN_EOF:
+		return "end of tag"
+	case TWIG_TOKEN_STRING:
+		return "`" + string(tok.quote) + tok.value + string(tok.quote) + "`"
+	default:
+		return "`" + tok.value + "`"
+	}
+}
+
+// twigPunctuation lists the operators and delimiters, the longest first
+// so that `**` is not read as two `*`.
+var twigPunctuation = []string{
+	"**", "//", "??", "?:", "==", "!=", "<=", ">=", "..",
+	"+", "-", "*", "/", "%", "~", "<", ">", "=",
+	"(", ")", "[", "]", "{", "}", ",", ":", ".", "|", "?",
+}
+
+// lexTwig splits the source of a tag into tokens. The last token is always
+// TWIG_TOKEN_EOF.
+func lexTwig(source string) ([]twigToken, error) {
+	src := []rune(source)
+	tokens := []twigToken{}
+
+	for i := 0; i < len(src); {
+		ch := src[i]
+
+		switch {
+		case unicode.IsSpace(ch):
+			i++
+		case unicode.IsDigit(ch):
+			start := i
+			for i < len(src) && unicode.IsDigit(src[i]) {
+				i++
+			}
+
+			// the digits after a `.` are an attribute such as `items.0`, and
+			// `1..5` is a range rather than a decimal
+			afterDot := len(tokens) != 0 && tokens[len(tokens)-1].value == "." && tokens[len(tokens)-1].kind == TWIG_TOKEN_PUNCT
+			if !afterDot && i+1 < len(src) && src[i] == '.' && unicode.IsDigit(src[i+1]) {
+				for i++; i < len(src) && unicode.IsDigit(src[i]); i++ {
+				}
+			}
+
+			// exponents such as `1e3` or `2.5E-4`
+			if !afterDot && i < len(src) && (src[i] == 'e' || src[i] == 'E') {
+				digits := i + 1
+				if digits < len(src) && (src[digits] == '+' || src[digits] == '-') {
+					digits++
+				}
+
+				if digits < len(src) && unicode.IsDigit(src[digits]) {
+					for i = digits; i < len(src) && unicode.IsDigit(src[i]); i++ {
+					}
+				}
+			}
+
+			tokens = append(tokens, twigToken{kind: TWIG_TOKEN_NUMBER, value: string(src[start:i])})
+		case ch == '_' || unicode.IsLetter(ch):
+			start := i
+			for i < len(src) && (src[i] == '_' || unicode.IsLetter(src[i]) || unicode.IsDigit(src[i])) {
+				i++
+			}
+
+			// the bitwise operators `b-and`, `b-or` and `b-xor`
+			if string(src[start:i]) == "b" && i < len(src) && src[i] == '-' {
+				end := i + 1
+				for end < len(src) && unicode.IsLetter(src[end]) {
+					end++
+				}
+
+				switch string(src[i+1 : end]) {
+				case "and", "or", "xor":
+					i = end
+				}
+			}
+
+			tokens = append(tokens, twigToken{kind: TWIG_TOKEN_NAME, value: string(src[start:i])})
+		case ch == '"' || ch == '\'':
+			end, err := skipTwigString(src, i+1, ch)
+			if err != nil {
+				return nil, err
+			}
+
+			value := string(src[i+1 : end-1])
+			if ch == '\'' {
+				value = unescapeTwigString(value)
+			}
+
+			tokens = append(tokens, twigToken{kind: TWIG_TOKEN_STRING, value: value, quote: ch})
+			i = end
+		default:
+			punct, rest := "", string(src[i:])
+			for _, candidate := range twigPunctuation {
+				if strings.HasPrefix(rest, candidate) {
+					punct = candidate
+					break
+				}
+			}
+
+			if len(punct) == 0 {
+				return nil, fmt.Errorf("unexpected character `%c`", ch)
+			}
+
+			tokens = append(tokens, twigToken{kind: TWIG_TOKEN_PUNCT, value: punct})
+			i += len(punct)
+		}
+	}
+
+	return append(tokens, twigToken{kind: TWIG_TOKEN_EOF}), nil
+}
+
+// skipTwigString returns the index right after the closing quote of the
+// string starting at i. Interpolations in double-quoted strings may contain
+// strings of their own.
+func skipTwigString(src []rune, i int, quote rune) (int, error) {
+	for i < len(src) {
+		switch {
+		case src[i] == '\\':
+			i += 2
+		case src[i] == quote:
+			return i + 1, nil
+		case quote == '"' && src[i] == '#' && i+1 < len(src) && src[i+1] == '{':
+			end, err := skipTwigInterpolation(src, i+2)
+			if err != nil {
+				return 0, err
+			}
+			i = end
+		default:
+			i++
+		}
+	}
+	return 0, fmt.Errorf("string is not closed")
+}
+
+// skipTwigInterpolation returns the index right after the `}` closing the
+// interpolation whose expression starts at i.
+func skipTwigInterpolation(src []rune, i int) (int, error) {
+	for depth := 1; i < len(src); {
+		switch src[i] {
+		case '"', '\'':
+			end, err := skipTwigString(src, i+1, src[i])
+			if err != nil {
+				return 0, err
+			}
+			i = end
+			continue
+		case '{':
+			depth++
+		case '}':
+			if depth--; depth == 0 {
+				return i + 1, nil
+			}
+		}
+		i++
+	}
+	return 0, fmt.Errorf("string interpolation is not closed")
+}
+
+// unescapeTwigString replaces the escape sequences of a string literal.
+// Unknown sequences such as `\'` or `\#` are replaced by the escaped
+// character.
+func unescapeTwigString(value string) string {
+	if !strings.ContainsRune(value, '\\') {
+		return value
+	}
+
+	sb := &strings.Builder{}
+	src := []rune(value)
+	for i := 0; i < len(src); i++ {
+		if src[i] != '\\' || i+1 == len(src) {
+			sb.WriteRune(src[i])
+			continue
+		}
+
+		i++
+		switch src[i] {
+		case 'n':
+			sb.WriteRune('\n')
+		case 't':
+			sb.WriteRune('\t')
+		case 'r':
+			sb.WriteRune('\r')
+		default:
+			sb.WriteRune(src[i])
+		}
+	}
+	return sb.String()
+}
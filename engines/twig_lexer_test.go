@@ -0,0 +1,63 @@
+package engines
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestLexTwig(t *testing.T) {
+	name := func(value string) twigToken { return twigToken{kind: TWIG_TOKEN_NAME, value: value} }
+	number := func(value string) twigToken { return twigToken{kind: TWIG_TOKEN_NUMBER, value: value} }
+	punct := func(value string) twigToken { return twigToken{kind: TWIG_TOKEN_PUNCT, value: value} }
+	str := func(value string, quote rune) twigToken {
+		return twigToken{kind: TWIG_TOKEN_STRING, value: value, quote: quote}
+	}
+
+	cases := []struct {
+		source string
+		tokens []twigToken
+	}{
+		{"", nil},
+		{"1 + 2.5", []twigToken{number("1"), punct("+"), number("2.5")}},
+		{"1..5", []twigToken{number("1"), punct(".."), number("5")}},
+		{"items.0.name", []twigToken{name("items"), punct("."), number("0"), punct("."), name("name")}},
+		{"1e3 2.5E-4 1e+2", []twigToken{number("1e3"), number("2.5E-4"), number("1e+2")}},
+		{"1 else", []twigToken{number("1"), name("else")}},
+		{"2 ** 3 // 4", []twigToken{number("2"), punct("**"), number("3"), punct("//"), number("4")}},
+		{"a ?? b ?: c", []twigToken{name("a"), punct("??"), name("b"), punct("?:"), name("c")}},
+		{"a b-and b b-or 1 b-xor 2", []twigToken{name("a"), name("b-and"), name("b"), name("b-or"), number("1"), name("b-xor"), number("2")}},
+		{"b - 1", []twigToken{name("b"), punct("-"), number("1")}},
+		{"b-band", []twigToken{name("b"), punct("-"), name("band")}},
+		{"a not in b", []twigToken{name("a"), name("not"), name("in"), name("b")}},
+		{`'it\'s' "a #{b ~ "}"} c"`, []twigToken{str("it's", '\''), str(`a #{b ~ "}"} c`, '"')}},
+		{`'a\nb'`, []twigToken{str("a\nb", '\'')}},
+	}
+
+	for _, c := range cases {
+		tokens, err := lexTwig(c.source)
+		if err != nil {
+			t.Errorf("%q: unexpected error: %s", c.source, err)
+			continue
+		}
+
+		expected := append(c.tokens, twigToken{kind: TWIG_TOKEN_EOF})
+		if !reflect.DeepEqual(tokens, expected) {
+			t.Errorf("%q: expected %v, got %v", c.source, expected, tokens)
+		}
+	}
+}
+
+func TestLexTwigErrors(t *testing.T) {
+	cases := map[string]string{
+		"a $ b":   "unexpected character `$`",
+		"'abc":    "string is not closed",
+		`"a #{b"`: "string is not closed",
+		`"a #{b`:  "string interpolation is not closed",
+	}
+
+	for source, expected := range cases {
+		if _, err := lexTwig(source); err == nil || err.Error() != expected {
+			t.Errorf("%q: expected error %q, got %v", source, expected, err)
+		}
+	}
+}
@@ -0,0 +1,550 @@
+package engines
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"text/scanner"
+)
+
+// twigClosingTags are the tags that continue or end the statement they
+// belong to. They are handled by the statement being scanned.
+var twigClosingTags = map[string]bool{
+	"else":     true,
+	"elseif":   true,
+	"endif":    true,
+	"endfor":   true,
+	"endset":   true,
+	"endblock": true,
+	"endembed": true,
+
+	// only reached when there is no verbatim tag to close
+	"endverbatim": true,
+}
+
+// twigEndTags maps the statements that have a body to the tag ending it.
+var twigEndTags = map[TwigNodeType]string{
+	TWIG_COND:    "endif",
+	TWIG_LOOP:    "endfor",
+	TWIG_CAPTURE: "endset",
+	TWIG_BLOCK:   "endblock",
+	TWIG_EMBED:   "endembed",
+}
+
+func (sc TwigScanner) unexpectedTag(tag string) error {
+	if sc.stack.Size() == 0 {
+		return fmt.Errorf("unexpected `%s` tag", tag)
+	}
+
+	expected := twigEndTags[sc.stack.Peek()]
+	if len(tag) == 0 {
+		return fmt.Errorf("unexpected end of template, expected `%s` tag", expected)
+	}
+	return fmt.Errorf("unexpected `%s` tag, expected `%s`", tag, expected)
+}
+
+// twigTag is a statement tag whose name has been read. The rest of the tag
+// is left in the parser.
+type twigTag struct {
+	name   string
+	parser *twigParser
+}
+
+// skipTagEnd consumes the whitespace trimmed after a statement or a
+// comment. Like Twig, the newline right after a `%}` or a `#}` without a
+// marker is dropped.
+func (sc TwigScanner) skipTagEnd(trim rune) {
+	if trim != 0 {
+		sc.skipTrimmed(trim)
+	} else if sc.scanner.Peek() == '\n' {
+		sc.scanner.Next()
+	}
+}
+
+// scanTag reads a statement tag and its name.
+func (sc TwigScanner) scanTag() (*twigTag, error) {
+	source, trim, err := sc.scanTagSource('%')
+	if err != nil {
+		return nil, err
+	}
+
+	sc.skipTagEnd(trim)
+
+	parser, err := newTwigParser(source)
+	if err != nil {
+		return nil, err
+	}
+
+	name, err := parser.expectName()
+	if err != nil {
+		return nil, err
+	}
+	return &twigTag{name: name, parser: parser}, nil
+}
+
+// scanBodyUntil scans the body of the statement until one of the given
+// closing tags. The tag found is returned.
+func (sc TwigScanner) scanBodyUntil(nodeType TwigNodeType, tags ...string) ([]TwigNode, *twigTag, error) {
+	sc.stack.Push(nodeType)
+	defer sc.stack.Pop()
+
+	body, tag, err := sc.scanBody()
+	if err != nil {
+		return nil, nil, err
+	} else if tag == nil {
+		return nil, nil, sc.unexpectedTag("")
+	}
+
+	for _, expected := range tags {
+		if tag.name == expected {
+			return body, tag, nil
+		}
+	}
+	return nil, nil, sc.unexpectedTag(tag.name)
+}
+
+func statement(node TwigNode) TwigNode {
+	return TwigNode{
+		node_type: TWIG_STMT,
+		children:  []TwigNode{node},
+	}
+}
+
+// scanStatement scans the rest of the given tag, including its body if it
+// has one.
+func (sc TwigScanner) scanStatement(tag *twigTag) ([]TwigNode, error) {
+	p := tag.parser
+
+	switch tag.name {
+	case "if":
+		condNode, err := sc.scanIf(p)
+		if err != nil {
+			return nil, err
+		}
+		return []TwigNode{statement(condNode)}, nil
+	case "for":
+		loopNode, err := sc.scanFor(p)
+		if err != nil {
+			return nil, err
+		}
+		return []TwigNode{statement(loopNode)}, nil
+	case "set":
+		return sc.scanSet(p)
+	case "block":
+		return sc.scanBlock(p)
+	case "extends", "use":
+		name, _, err := scanTemplateName(p)
+		if err != nil {
+			return nil, err
+		} else if len(name) == 0 {
+			return nil, fmt.Errorf("%s tag should have a template name as a string", tag.name)
+		} else if err := p.expectEnd(); err != nil {
+			return nil, err
+		}
+
+		nodeType := TWIG_EXTENDS
+		if tag.name == "use" {
+			nodeType = TWIG_USE
+		}
+		return []TwigNode{{node_type: nodeType, value: name}}, nil
+	case "verbatim":
+		if err := p.expectEnd(); err != nil {
+			return nil, err
+		}
+
+		text, err := sc.scanVerbatim()
+		if err != nil {
+			return nil, err
+		} else if len(text) == 0 {
+			return nil, nil
+		}
+		return []TwigNode{{node_type: TWIG_RAW, value: text}}, nil
+	case "include", "embed":
+		includeNode, err := sc.scanInclude(p, tag.name == "embed")
+		if err != nil {
+			return nil, err
+		}
+		return []TwigNode{includeNode}, nil
+	default:
+		return nil, fmt.Errorf("unknown tag `%s`", tag.name)
+	}
+}
+
+// scanIf scans `{% if cond %}...{% elseif cond %}...{% else %}...{% endif %}`.
+// Each `elseif` becomes a condition nested as the alternative.
+func (sc TwigScanner) scanIf(p *twigParser) (TwigNode, error) {
+	expr, err := p.parseExpression()
+	if err != nil {
+		return expr, err
+	} else if err := p.expectEnd(); err != nil {
+		return sc.error(err)
+	}
+
+	consequence, tag, err := sc.scanBodyUntil(TWIG_COND, "elseif", "else", "endif")
+	if err != nil {
+		return sc.error(err)
+	}
+
+	condNode := TwigNode{
+		node_type: TWIG_COND,
+		children: []TwigNode{
+			{node_type: TWIG_COND_EXPR, children: []TwigNode{expr}},
+			{node_type: TWIG_COND_CONSEQ, children: consequence},
+		},
+	}
+
+	switch tag.name {
+	case "elseif":
+		alternative, err := sc.scanIf(tag.parser)
+		if err != nil {
+			return alternative, err
+		}
+		condNode.children = append(condNode.children, alternative)
+	case "else":
+		if err := tag.parser.expectEnd(); err != nil {
+			return sc.error(err)
+		}
+
+		alternative, endTag, err := sc.scanBodyUntil(TWIG_COND, "endif")
+		if err != nil {
+			return sc.error(err)
+		} else if err := endTag.parser.expectEnd(); err != nil {
+			return sc.error(err)
+		}
+
+		condNode.children = append(condNode.children, TwigNode{
+			node_type: TWIG_COND_ALTER,
+			children:  alternative,
+		})
+	default:
+		if err := tag.parser.expectEnd(); err != nil {
+			return sc.error(err)
+		}
+	}
+
+	return condNode, nil
+}
+
+// scanFor scans `{% for [key,] value in items %}...{% else %}...{% endfor %}`.
+func (sc TwigScanner) scanFor(p *twigParser) (TwigNode, error) {
+	loopNode := TwigNode{node_type: TWIG_LOOP}
+
+	for {
+		target, err := p.expectName()
+		if err != nil {
+			return sc.error(err)
+		}
+
+		loopNode.children = append(loopNode.children, TwigNode{
+			node_type: TWIG_LOOP_TARGET,
+			value:     target,
+		})
+
+		if !p.accept(TWIG_TOKEN_PUNCT, ",") {
+			break
+		} else if len(loopNode.children) == 2 {
+			return sc.error(fmt.Errorf("for loop should have at most two targets"))
+		}
+	}
+
+	if err := p.expect(TWIG_TOKEN_NAME, "in"); err != nil {
+		return sc.error(err)
+	}
+
+	iterable, err := p.parseExpression()
+	if err != nil {
+		return iterable, err
+	} else if err := p.expectEnd(); err != nil {
+		return sc.error(err)
+	}
+
+	body, tag, err := sc.scanBodyUntil(TWIG_LOOP, "else", "endfor")
+	if err != nil {
+		return sc.error(err)
+	}
+
+	loopNode.children = append(loopNode.children,
+		TwigNode{node_type: TWIG_LOOP_ITERABLE, children: []TwigNode{iterable}},
+		TwigNode{node_type: TWIG_LOOP_BODY, children: body},
+	)
+
+	if tag.name == "else" {
+		if err := tag.parser.expectEnd(); err != nil {
+			return sc.error(err)
+		}
+
+		alternative, endTag, err := sc.scanBodyUntil(TWIG_LOOP, "endfor")
+		if err != nil {
+			return sc.error(err)
+		}
+
+		loopNode.children = append(loopNode.children, TwigNode{
+			node_type: TWIG_LOOP_ELSE,
+			children:  alternative,
+		})
+		tag = endTag
+	}
+
+	if err := tag.parser.expectEnd(); err != nil {
+		return sc.error(err)
+	}
+	return loopNode, nil
+}
+
+// scanSet scans `{% set a, b = x, y %}` into one assignment per variable,
+// or `{% set a %}...{% endset %}` into a capture.
+// twigNearestScope makes `set` update a variable defined before the tag,
+// such as an accumulator assigned inside a loop body, like Twig does.
+var twigNearestScope = TwigNode{node_type: TWIG_ASSIGN_SCOPE, value: "nearest"}
+
+func (sc TwigScanner) scanSet(p *twigParser) ([]TwigNode, error) {
+	names := []string{}
+	for {
+		name, err := p.expectName()
+		if err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+
+		if !p.accept(TWIG_TOKEN_PUNCT, ",") {
+			break
+		}
+	}
+
+	if !p.accept(TWIG_TOKEN_PUNCT, "=") {
+		if len(names) != 1 {
+			return nil, fmt.Errorf("set block should have exactly one variable")
+		} else if err := p.expectEnd(); err != nil {
+			return nil, err
+		}
+
+		body, tag, err := sc.scanBodyUntil(TWIG_CAPTURE, "endset")
+		if err != nil {
+			return nil, err
+		} else if err := tag.parser.expectEnd(); err != nil {
+			return nil, err
+		}
+
+		return []TwigNode{statement(TwigNode{
+			node_type: TWIG_CAPTURE,
+			value:     names[0],
+			children:  append([]TwigNode{twigNearestScope}, body...),
+		})}, nil
+	}
+
+	stmtNodes := make([]TwigNode, 0, len(names))
+	for i, name := range names {
+		if i > 0 && !p.accept(TWIG_TOKEN_PUNCT, ",") {
+			return nil, fmt.Errorf("expected %d values to set, got %d", len(names), i)
+		}
+
+		expr, err := p.parseExpression()
+		if err != nil {
+			return nil, err
+		}
+
+		stmtNodes = append(stmtNodes, statement(TwigNode{
+			node_type: TWIG_ASSIGN,
+			value:     name,
+			children:  []TwigNode{twigNearestScope, expr},
+		}))
+	}
+
+	if err := p.expectEnd(); err != nil {
+		return nil, err
+	}
+	return stmtNodes, nil
+}
+
+// twigTemplateName turns a template path such as `layouts/base.twig` into
+// the name the template is loaded as.
+func twigTemplateName(path string) string {
+	fileName := filepath.Base(path)
+	return strings.TrimSuffix(fileName, filepath.Ext(fileName))
+}
+
+// scanTemplateName parses the template name of a tag. If the name is a
+// string, it is returned as the name of the template. Otherwise only the
+// expression is returned.
+func scanTemplateName(p *twigParser) (string, TwigNode, error) {
+	expr, err := p.parseExpression()
+	if err != nil {
+		return "", expr, err
+	} else if expr.node_type == TWIG_STRING {
+		return twigTemplateName(expr.value), expr, nil
+	}
+	return "", expr, nil
+}
+
+// scanBlock scans `{% block name %}...{% endblock %}` or the short form
+// `{% block name expr %}`. The block is defined and rendered in place,
+// so that it also works in templates that do not extend another one.
+func (sc TwigScanner) scanBlock(p *twigParser) ([]TwigNode, error) {
+	name, err := p.expectName()
+	if err != nil {
+		return nil, err
+	}
+
+	var body []TwigNode
+	if !p.atEnd() {
+		expr, err := p.parseExpression()
+		if err != nil {
+			return nil, err
+		} else if err := p.expectEnd(); err != nil {
+			return nil, err
+		}
+		body = []TwigNode{{node_type: TWIG_DISPLAY, children: []TwigNode{expr}}}
+	} else {
+		var tag *twigTag
+		body, tag, err = sc.scanBodyUntil(TWIG_BLOCK, "endblock")
+		if err != nil {
+			return nil, err
+		}
+
+		// the name after endblock is optional
+		if !tag.parser.atEnd() {
+			endName, err := tag.parser.expectName()
+			if err != nil {
+				return nil, err
+			} else if endName != name {
+				return nil, fmt.Errorf("`%s` block is closed by `endblock %s`", name, endName)
+			}
+		}
+
+		if err := tag.parser.expectEnd(); err != nil {
+			return nil, err
+		}
+	}
+
+	return []TwigNode{
+		{node_type: TWIG_BLOCK, value: name, children: body},
+		statement(TwigNode{node_type: TWIG_YIELD, value: name}),
+	}, nil
+}
+
+// scanInclude scans `{% include 'name' ignore missing with vars only %}`
+// or the embed tag, whose body holds the blocks to override. Like Twig,
+// the blocks of the including template do not apply to the included one.
+func (sc TwigScanner) scanInclude(p *twigParser, isEmbed bool) (TwigNode, error) {
+	tag, includeNode := "include", TwigNode{
+		node_type: TWIG_INCLUDE,
+		children:  []TwigNode{{node_type: TWIG_INCLUDE_OWN_BLOCKS}},
+	}
+	if isEmbed {
+		tag, includeNode.node_type = "embed", TWIG_EMBED
+	}
+
+	name, expr, err := scanTemplateName(p)
+	if err != nil {
+		return expr, err
+	} else if len(name) != 0 {
+		includeNode.value = name
+	} else {
+		includeNode.children = append(includeNode.children, TwigNode{
+			node_type: TWIG_INCLUDE_NAME,
+			children:  []TwigNode{expr},
+		})
+	}
+
+	for !p.atEnd() {
+		option, err := p.expectName()
+		if err != nil {
+			return sc.error(err)
+		}
+
+		switch option {
+		case "ignore":
+			if err := p.expect(TWIG_TOKEN_NAME, "missing"); err != nil {
+				return sc.error(err)
+			}
+			includeNode.children = append(includeNode.children, TwigNode{node_type: TWIG_INCLUDE_IGNORE_MISSING})
+		case "with":
+			vars, err := p.parseExpression()
+			if err != nil {
+				return vars, err
+			}
+			includeNode.children = append(includeNode.children, TwigNode{
+				node_type: TWIG_INCLUDE_WITH,
+				children:  []TwigNode{vars},
+			})
+		case "only":
+			includeNode.children = append(includeNode.children, TwigNode{node_type: TWIG_INCLUDE_ONLY})
+		default:
+			return sc.error(fmt.Errorf("unexpected `%s` in %s tag", option, tag))
+		}
+	}
+
+	if !isEmbed {
+		return includeNode, nil
+	}
+
+	body, endTag, err := sc.scanBodyUntil(TWIG_EMBED, "endembed")
+	if err != nil {
+		return sc.error(err)
+	} else if err := endTag.parser.expectEnd(); err != nil {
+		return sc.error(err)
+	}
+
+	// only the block definitions are kept, they are rendered by the
+	// embedded template
+	for _, cn := range body {
+		switch cn.node_type {
+		case TWIG_BLOCK:
+			includeNode.children = append(includeNode.children, cn)
+		case TWIG_COMMENT:
+			continue
+		case TWIG_STMT:
+			if cn.children[0].node_type != TWIG_YIELD {
+				return sc.error(fmt.Errorf("embed tag should only contain blocks"))
+			}
+		case TWIG_RAW:
+			if len(strings.TrimSpace(cn.value)) != 0 {
+				return sc.error(fmt.Errorf("embed tag should only contain blocks"))
+			}
+		default:
+			return sc.error(fmt.Errorf("embed tag should only contain blocks"))
+		}
+	}
+
+	return includeNode, nil
+}
+
+var twigEndVerbatim = regexp.MustCompile(`^\{%([-~]?)\s*endverbatim\s*([-~]?)%\}$`)
+
+// scanVerbatim reads the text up to `{% endverbatim %}` as it is, so that
+// it may contain `{{` and `{%`.
+func (sc TwigScanner) scanVerbatim() (string, error) {
+	defer sc.tokenBuilder.Reset()
+
+	for tok := sc.scanner.Next(); tok != scanner.EOF; tok = sc.scanner.Next() {
+		sc.tokenBuilder.WriteRune(tok)
+		if tok != '}' {
+			continue
+		}
+
+		text := sc.tokenBuilder.String()
+		start := strings.LastIndex(text, "{%")
+		if start == -1 {
+			continue
+		}
+
+		match := twigEndVerbatim.FindStringSubmatch(text[start:])
+		if match == nil {
+			continue
+		}
+
+		var trimBefore, trimAfter rune
+		if len(match[1]) != 0 {
+			trimBefore = rune(match[1][0])
+		}
+		if len(match[2]) != 0 {
+			trimAfter = rune(match[2][0])
+		}
+
+		sc.skipTagEnd(trimAfter)
+		return strings.TrimRightFunc(text[:start], twigTrimFunc(trimBefore)), nil
+	}
+
+	return "", fmt.Errorf("unexpected end of template, expected `endverbatim` tag")
+}
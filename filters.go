@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+
+	types "github.com/nedpals/hulma/node_types"
+)
+
+// Arguments are the evaluated arguments of a filter or a function call.
+type Arguments struct {
+	Positional []any
+	Named      map[string]any
+}
+
+func (args Arguments) Len() int {
+	return len(args.Positional) + len(args.Named)
+}
+
+// Filter transforms a value, optionally configured by arguments such as
+// the length in `truncate(30, "...")`.
+type Filter interface {
+	Apply(value any, args Arguments) (any, error)
+}
+
+// FilterFunc is a filter without arguments.
+type FilterFunc func(value any) (any, error)
+
+func (filterFn FilterFunc) Apply(value any, args Arguments) (any, error) {
+	if args.Len() != 0 {
+		return nil, fmt.Errorf("expects no arguments, got %d", args.Len())
+	}
+	return filterFn(value)
+}
+
+// FilterArgsFunc is a filter that receives the arguments it is called with.
+type FilterArgsFunc func(value any, args Arguments) (any, error)
+
+func (filterFn FilterArgsFunc) Apply(value any, args Arguments) (any, error) {
+	return filterFn(value, args)
+}
+
+// collectArguments evaluates the `filter_argument` nodes. An argument
+// preceded by a `filter_parameter` node is named after it, otherwise it is
+// positional. The value of an argument is either its child expression or
+// its own value as a string.
+func collectArguments(nodes []Node, tmpl TemplateData) (Arguments, error) {
+	args := Arguments{}
+	name := ""
+	hasName := false
+
+	for _, child := range nodes {
+		fType := types.FunctionNodeType(child.Type)
+
+		switch fType {
+		case types.NODE_TYPE_FUNCTION_PARAMETER:
+			if hasName {
+				return args, fmt.Errorf("`%s` parameter has no argument", name)
+			} else if len(child.Value) == 0 {
+				return args, fmt.Errorf("parameter should have a name")
+			}
+			name = child.Value
+			hasName = true
+		case types.NODE_TYPE_FUNCTION_ARGUMENT:
+			if len(child.Children) != 0 && len(child.Value) != 0 {
+				return args, fmt.Errorf("argument value should not be a content or an expression node at the same time")
+			}
+
+			var value any = child.Value
+			if len(child.Children) != 0 {
+				evaluatedValue, err := child.Children[0].evaluateExpression(tmpl)
+				if err != nil {
+					return args, err
+				}
+				value = evaluatedValue
+			}
+
+			if !hasName {
+				if len(args.Named) != 0 {
+					return args, fmt.Errorf("positional argument should not follow named arguments")
+				}
+				args.Positional = append(args.Positional, value)
+				continue
+			}
+
+			if args.Named == nil {
+				args.Named = make(map[string]any)
+			} else if _, exists := args.Named[name]; exists {
+				return args, fmt.Errorf("`%s` argument is passed more than once", name)
+			}
+			args.Named[name] = value
+			hasName = false
+		default:
+			return args, fmt.Errorf("invalid filter type: %s", fType)
+		}
+	}
+
+	if hasName {
+		return args, fmt.Errorf("`%s` parameter has no argument", name)
+	}
+	return args, nil
+}
+
+// evaluateFilter applies the filter to the first child. The remaining
+// children are the arguments of the filter.
+func (node Node) evaluateFilter(tmpl TemplateData) (any, error) {
+	filter, filterExists := tmpl.Filters[node.Value]
+	if !filterExists {
+		return nil, fmt.Errorf("filter `%s` does not exist", node.Value)
+	} else if len(node.Children) == 0 {
+		return nil, fmt.Errorf("filter node should have a subject")
+	}
+
+	evaluatedValue, err := node.Children[0].evaluateExpression(tmpl)
+	if err != nil {
+		return "", err
+	}
+
+	args, err := collectArguments(node.Children[1:], tmpl)
+	if err != nil {
+		return nil, err
+	}
+
+	return applyFilter(node.Value, filter, evaluatedValue, args)
+}
+
+func applyFilter(name string, filter Filter, value any, args Arguments) (any, error) {
+	result, err := filter.Apply(value, args)
+	if err != nil {
+		return nil, fmt.Errorf("`%s` filter: %w", name, err)
+	}
+	return result, nil
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	stdjson "encoding/json"
+	"fmt"
+	"math"
+	"math/big"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+type BoolStyle string
+
+const (
+	// BOOL_STYLE_WORDS renders booleans as `true` and `false`.
+	BOOL_STYLE_WORDS BoolStyle = "words"
+	// BOOL_STYLE_TWIG renders booleans like PHP does: `1` and nothing.
+	BOOL_STYLE_TWIG BoolStyle = "twig"
+)
+
+func (style BoolStyle) String() string {
+	return string(style)
+}
+
+func (style *BoolStyle) Set(value string) error {
+	switch BoolStyle(value) {
+	case BOOL_STYLE_WORDS, BOOL_STYLE_TWIG:
+		*style = BoolStyle(value)
+		return nil
+	default:
+		return fmt.Errorf("unknown bool style: %s", value)
+	}
+}
+
+func (*BoolStyle) Type() string {
+	return "bool_style"
+}
+
+type CollectionStyle string
+
+const (
+	// COLLECTION_STYLE_JOIN renders the items of arrays and the values of
+	// objects (in key order) joined by the separator.
+	COLLECTION_STYLE_JOIN CollectionStyle = "join"
+	// COLLECTION_STYLE_JSON renders arrays and objects as JSON.
+	COLLECTION_STYLE_JSON CollectionStyle = "json"
+)
+
+func (style CollectionStyle) String() string {
+	return string(style)
+}
+
+func (style *CollectionStyle) Set(value string) error {
+	switch CollectionStyle(value) {
+	case COLLECTION_STYLE_JOIN, COLLECTION_STYLE_JSON:
+		*style = CollectionStyle(value)
+		return nil
+	default:
+		return fmt.Errorf("unknown collection style: %s", value)
+	}
+}
+
+func (*CollectionStyle) Type() string {
+	return "collection_style"
+}
+
+// Formatter converts values into the text written to the output. The zero
+// value is ready to use.
+type Formatter struct {
+	// FloatPrecision is the number of decimals of floats that are not
+	// integers. Zero or less uses the shortest exact representation.
+	FloatPrecision  int
+	BoolStyle       BoolStyle
+	CollectionStyle CollectionStyle
+	// Separator joins the items of collections. Defaults to ", ".
+	Separator string
+}
+
+func (f Formatter) Format(value any) string {
+	switch v := value.(type) {
+	case nil, Undefined:
+		return ""
+	case string:
+		return v
+	case bool:
+		if f.BoolStyle == BOOL_STYLE_TWIG {
+			if v {
+				return "1"
+			}
+			return ""
+		} else if v {
+			return "true"
+		}
+		return "false"
+	case float32:
+		return f.formatFloat(float64(v))
+	case float64:
+		return f.formatFloat(v)
+	case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64:
+		return fmt.Sprintf("%d", v)
+	case stdjson.Number:
+		return f.formatExactNumber(v)
+	case fmt.Stringer:
+		return v.String()
+	case error:
+		return v.Error()
+	}
+
+	rv := reflect.ValueOf(value)
+	switch rv.Kind() {
+	case reflect.Slice, reflect.Array, reflect.Map:
+		return f.formatCollection(value, rv)
+	case reflect.Pointer:
+		if rv.IsNil() {
+			return ""
+		}
+		return f.Format(rv.Elem().Interface())
+	default:
+		return fmt.Sprintf("%v", value)
+	}
+}
+
+func (f Formatter) formatFloat(value float64) string {
+	if math.IsInf(value, 0) || math.IsNaN(value) {
+		return strconv.FormatFloat(value, 'f', -1, 64)
+	} else if value == math.Trunc(value) && math.Abs(value) < 1e21 {
+		return strconv.FormatFloat(value, 'f', 0, 64)
+	} else if f.FloatPrecision > 0 {
+		return strconv.FormatFloat(value, 'f', f.FloatPrecision, 64)
+	}
+	return strconv.FormatFloat(value, 'f', -1, 64)
+}
+
+// formatExactNumber keeps the text of a json.Number, so that `19.90` keeps
+// its trailing zero and large numbers keep all of their digits. With a
+// float precision, decimals are rounded without converting them to a
+// float64.
+func (f Formatter) formatExactNumber(value stdjson.Number) string {
+	if f.FloatPrecision <= 0 {
+		return value.String()
+	}
+
+	exactNum, ok := new(big.Rat).SetString(value.String())
+	if !ok {
+		return value.String()
+	} else if exactNum.IsInt() {
+		return exactNum.Num().String()
+	}
+	return exactNum.FloatString(f.FloatPrecision)
+}
+
+func (f Formatter) formatCollection(value any, rv reflect.Value) string {
+	if f.CollectionStyle == COLLECTION_STYLE_JSON {
+		encoded, err := json.MarshalToString(value)
+		if err != nil {
+			return fmt.Sprintf("%v", value)
+		}
+		return encoded
+	}
+
+	separator := f.Separator
+	if len(separator) == 0 {
+		separator = ", "
+	}
+
+	items := make([]string, 0, rv.Len())
+	if rv.Kind() == reflect.Map {
+		keys := rv.MapKeys()
+		sortMapKeys(keys)
+
+		for _, key := range keys {
+			items = append(items, f.Format(rv.MapIndex(key).Interface()))
+		}
+	} else {
+		for i := 0; i < rv.Len(); i++ {
+			items = append(items, f.Format(rv.Index(i).Interface()))
+		}
+	}
+
+	return strings.Join(items, separator)
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	stdjson "encoding/json"
+	"testing"
+)
+
+func TestFormatExactNumber(t *testing.T) {
+	cases := []struct {
+		value     string
+		precision int
+		expected  string
+	}{
+		{"19.90", 0, "19.90"},
+		{"3.0", 0, "3.0"},
+		{"1e3", 0, "1e3"},
+		{"12345678901234567890.125", 0, "12345678901234567890.125"},
+		{"19.90", 2, "19.90"},
+		{"1.5", 2, "1.50"},
+		{"2.345", 2, "2.35"},
+		{"-0.005", 2, "-0.01"},
+		{"3.0", 2, "3"},
+		{"1e3", 2, "1000"},
+	}
+
+	for _, c := range cases {
+		f := Formatter{FloatPrecision: c.precision}
+		if output := f.Format(stdjson.Number(c.value)); output != c.expected {
+			t.Errorf("%s with a precision of %d: expected %q, got %q", c.value, c.precision, c.expected, output)
+		}
+	}
+}
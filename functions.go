@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+
+	types "github.com/nedpals/hulma/node_types"
+)
+
+// Function is called by `function` nodes with their evaluated arguments.
+type Function interface {
+	Call(args Arguments) (any, error)
+}
+
+// FunctionFunc receives the arguments as a single value when there is only
+// one, as a map when some of them are named (positional ones are keyed by
+// their index) or else as a slice. Without arguments, it receives nil.
+type FunctionFunc func(arguments any) (any, error)
+
+func (functionFn FunctionFunc) Call(args Arguments) (any, error) {
+	if args.Len() == 0 {
+		return functionFn(nil)
+	} else if len(args.Named) != 0 {
+		parameters := make(map[string]any, args.Len())
+		for i, value := range args.Positional {
+			parameters[strconv.Itoa(i)] = value
+		}
+		for name, value := range args.Named {
+			parameters[name] = value
+		}
+		return functionFn(parameters)
+	} else if len(args.Positional) == 1 {
+		return functionFn(args.Positional[0])
+	}
+	return functionFn(args.Positional)
+}
+
+func (node Node) evaluateFunction(tmpl TemplateData) (any, error) {
+	if node.Type != types.NodeType(types.NODE_TYPE_FUNCTION) {
+		return nil, fmt.Errorf("node is not a function call")
+	}
+
+	function, functionExists := tmpl.Functions[node.Value]
+	filter, filterExists := tmpl.Filters[node.Value]
+	if !functionExists && !filterExists {
+		return nil, fmt.Errorf("function `%s` does not exist", node.Value)
+	}
+
+	args, err := collectArguments(node.Children, tmpl)
+	if err != nil {
+		return "", err
+	}
+
+	if functionExists {
+		result, err := function.Call(args)
+		if err != nil {
+			return nil, fmt.Errorf("`%s` function: %w", node.Value, err)
+		}
+		return result, nil
+	} else if len(args.Positional) == 0 {
+		return nil, fmt.Errorf("filter `%s` should be called with the value to filter", node.Value)
+	}
+
+	// a filter called as a function takes the value to filter as its first
+	// argument
+	value := args.Positional[0]
+	args.Positional = args.Positional[1:]
+	return applyFilter(node.Value, filter, value, args)
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Globals are the variables visible to every template. The context data of
+// a render shadows them.
+type Globals map[string]any
+
+func (globals Globals) String() string {
+	names := make([]string, 0, len(globals))
+	for name := range globals {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	pairs := make([]string, len(names))
+	for i, name := range names {
+		pairs[i] = fmt.Sprintf("%s=%v", name, globals[name])
+	}
+	return strings.Join(pairs, ",")
+}
+
+// Set parses a `key=value` pair. The value is kept as a string.
+func (globals Globals) Set(pair string) error {
+	name, value, found := strings.Cut(pair, "=")
+	if !found || len(name) == 0 {
+		return fmt.Errorf("global should be in the form of key=value: %s", pair)
+	}
+	globals[name] = value
+	return nil
+}
+
+func (Globals) Type() string {
+	return "key=value"
+}
@@ -0,0 +1,48 @@
+package main
+
+import "testing"
+
+func TestGlobals(t *testing.T) {
+	testApp, err := newTwigApp(
+		twigFile{"page.twig", "{{ site }}|{{ version }}|{% include 'part' with {} only %}|{{ lazy }}"},
+		twigFile{"part.twig", "{{ site }}"},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	calls := 0
+	testApp.RegisterGlobal("site", "hulma")
+	testApp.RegisterGlobal("version", "1.0")
+	testApp.RegisterGlobal("lazy", Lazy(func() (any, error) {
+		calls++
+		return calls, nil
+	}))
+
+	expected := "hulma|2.0|hulma|1"
+	if output, err := testApp.Render("page", map[string]any{"version": "2.0"}); err != nil || output != expected {
+		t.Errorf("expected %q, got %q, %v", expected, output, err)
+	}
+
+	// lazy globals are computed once per render
+	if output, err := testApp.Render("page", nil); err != nil || output != "hulma|1.0|hulma|2" {
+		t.Errorf("expected the globals to be used without context data, got %q, %v", output, err)
+	}
+}
+
+func TestGlobalsFlag(t *testing.T) {
+	globals := Globals{}
+	for _, pair := range []string{"site=hulma", "empty=", "url=a=b"} {
+		if err := globals.Set(pair); err != nil {
+			t.Errorf("%s: unexpected error: %s", pair, err)
+		}
+	}
+
+	if expected := "empty=,site=hulma,url=a=b"; globals.String() != expected {
+		t.Errorf("expected %q, got %q", expected, globals.String())
+	}
+
+	if err := globals.Set("=value"); err == nil {
+		t.Errorf("expected a global without a name to be rejected")
+	}
+}
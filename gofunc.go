@@ -0,0 +1,198 @@
+package main
+
+import (
+	stdjson "encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+)
+
+// GoFunc wraps a plain Go function so that it can be registered as a
+// function or a filter. The arguments are converted to the types of the
+// parameters and the function may return an error as its last result.
+type GoFunc struct {
+	fn       reflect.Value
+	fnType   reflect.Type
+	hasError bool
+}
+
+// NewGoFunc checks that the value is a function returning a value, a value
+// and an error, or only an error.
+func NewGoFunc(fn any) (*GoFunc, error) {
+	fnValue := reflect.ValueOf(fn)
+	if fnValue.Kind() != reflect.Func || fnValue.IsNil() {
+		return nil, fmt.Errorf("expected a function, got %T", fn)
+	}
+
+	fnType := fnValue.Type()
+	goFn := &GoFunc{fn: fnValue, fnType: fnType}
+
+	switch fnType.NumOut() {
+	case 1:
+		goFn.hasError = fnType.Out(0) == errorType
+	case 2:
+		if fnType.Out(1) != errorType {
+			return nil, fmt.Errorf("second result of %s should be an error", fnType)
+		}
+		goFn.hasError = true
+	default:
+		return nil, fmt.Errorf("%s should return a value, an error, or both", fnType)
+	}
+
+	return goFn, nil
+}
+
+func (goFn *GoFunc) Call(args Arguments) (any, error) {
+	if len(args.Named) != 0 {
+		return nil, fmt.Errorf("named arguments are not supported")
+	}
+
+	numIn := goFn.fnType.NumIn()
+	if goFn.fnType.IsVariadic() {
+		if len(args.Positional) < numIn-1 {
+			return nil, fmt.Errorf("expects at least %d arguments, got %d", numIn-1, len(args.Positional))
+		}
+	} else if len(args.Positional) != numIn {
+		return nil, fmt.Errorf("expects %d arguments, got %d", numIn, len(args.Positional))
+	}
+
+	in := make([]reflect.Value, len(args.Positional))
+	for i, arg := range args.Positional {
+		paramType := goFn.paramType(i)
+		converted, err := convertArgument(arg, paramType)
+		if err != nil {
+			return nil, fmt.Errorf("argument %d: %s", i+1, err.Error())
+		}
+		in[i] = converted
+	}
+
+	out := goFn.fn.Call(in)
+	if goFn.hasError {
+		if err, _ := out[len(out)-1].Interface().(error); err != nil {
+			return nil, err
+		} else if len(out) == 1 {
+			return nil, nil
+		}
+	}
+	return out[0].Interface(), nil
+}
+
+// Apply calls the function with the value to filter as the first argument.
+func (goFn *GoFunc) Apply(value any, args Arguments) (any, error) {
+	positional := make([]any, 0, len(args.Positional)+1)
+	args.Positional = append(append(positional, value), args.Positional...)
+	return goFn.Call(args)
+}
+
+func (goFn *GoFunc) paramType(i int) reflect.Type {
+	numIn := goFn.fnType.NumIn()
+	if goFn.fnType.IsVariadic() && i >= numIn-1 {
+		return goFn.fnType.In(numIn - 1).Elem()
+	}
+	return goFn.fnType.In(i)
+}
+
+// convertArgument converts a template value to the type of a parameter.
+// Numbers are converted between numeric types as long as they fit, and
+// arrays and objects are converted item by item.
+func convertArgument(value any, target reflect.Type) (reflect.Value, error) {
+	if _, isUndefined := value.(Undefined); isUndefined {
+		value = nil
+	}
+
+	if value == nil {
+		switch target.Kind() {
+		case reflect.Interface, reflect.Pointer, reflect.Map, reflect.Slice, reflect.Func:
+			return reflect.Zero(target), nil
+		default:
+			return reflect.Value{}, fmt.Errorf("cannot use null as %s", target)
+		}
+	}
+
+	rv := reflect.ValueOf(value)
+	if rv.Type().AssignableTo(target) {
+		return rv, nil
+	}
+
+	switch target.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if number, ok := integerArgument(value); ok {
+			converted := reflect.New(target).Elem()
+			if !converted.OverflowInt(number) {
+				converted.SetInt(number)
+				return converted, nil
+			}
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if number, ok := integerArgument(value); ok && number >= 0 {
+			converted := reflect.New(target).Elem()
+			if !converted.OverflowUint(uint64(number)) {
+				converted.SetUint(uint64(number))
+				return converted, nil
+			}
+		}
+	case reflect.Float32, reflect.Float64:
+		if number, ok := asNumber(value); ok {
+			converted := reflect.New(target).Elem()
+			converted.SetFloat(toFloat(number))
+			return converted, nil
+		}
+	case reflect.String:
+		if number, ok := value.(stdjson.Number); ok {
+			return reflect.ValueOf(number.String()).Convert(target), nil
+		} else if rv.Kind() == reflect.String {
+			return rv.Convert(target), nil
+		}
+	case reflect.Bool:
+		if rv.Kind() == reflect.Bool {
+			return rv.Convert(target), nil
+		}
+	case reflect.Slice:
+		if rv.Kind() == reflect.Slice || rv.Kind() == reflect.Array {
+			converted := reflect.MakeSlice(target, rv.Len(), rv.Len())
+			for i := 0; i < rv.Len(); i++ {
+				item, err := convertArgument(rv.Index(i).Interface(), target.Elem())
+				if err != nil {
+					return reflect.Value{}, fmt.Errorf("item %d: %s", i, err.Error())
+				}
+				converted.Index(i).Set(item)
+			}
+			return converted, nil
+		}
+	case reflect.Map:
+		if rv.Kind() == reflect.Map && target.Key().Kind() == reflect.String {
+			converted := reflect.MakeMapWithSize(target, rv.Len())
+			iter := rv.MapRange()
+			for iter.Next() {
+				key := keyString(iter.Key().Interface())
+				item, err := convertArgument(iter.Value().Interface(), target.Elem())
+				if err != nil {
+					return reflect.Value{}, fmt.Errorf("`%s`: %s", key, err.Error())
+				}
+				converted.SetMapIndex(reflect.ValueOf(key).Convert(target.Key()), item)
+			}
+			return converted, nil
+		}
+	}
+
+	return reflect.Value{}, fmt.Errorf("cannot use %T as %s", value, target)
+}
+
+// integerArgument returns the value as an int64 if it is a number without
+// a fractional part.
+func integerArgument(value any) (int64, bool) {
+	number, ok := asNumber(value)
+	if !ok {
+		return 0, false
+	}
+
+	switch n := number.(type) {
+	case int64:
+		return n, true
+	case float64:
+		if n == math.Trunc(n) && n >= math.MinInt64 && n < math.MaxInt64 {
+			return int64(n), true
+		}
+	}
+	return 0, false
+}
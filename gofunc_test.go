@@ -0,0 +1,161 @@
+package main
+
+import (
+	stdjson "encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestConvertArgument(t *testing.T) {
+	cases := []struct {
+		name     string
+		value    any
+		target   any
+		expected any
+		err      string
+	}{
+		{"int to int8", int64(42), int8(0), int8(42), ""},
+		{"whole float to int", 3.0, 0, 3, ""},
+		{"exact number to int", stdjson.Number("7"), 0, 7, ""},
+		{"fraction to int", 1.5, 0, nil, "cannot use float64 as int"},
+		{"overflowing int8", int64(300), int8(0), nil, "cannot use int64 as int8"},
+		{"int to uint8", int64(255), uint8(0), uint8(255), ""},
+		{"overflowing uint8", int64(300), uint8(0), nil, "cannot use int64 as uint8"},
+		{"negative uint", int64(-1), uint(0), nil, "cannot use int64 as uint"},
+		{"int to float32", int64(2), float32(0), float32(2), ""},
+		{"exact number to float", stdjson.Number("19.90"), 0.0, 19.9, ""},
+		{"exact number to string", stdjson.Number("19.90"), "", "19.90", ""},
+		{"number to string", int64(1), "", nil, "cannot use int64 as string"},
+		{"string to bool", "true", false, nil, "cannot use string as bool"},
+		{"null to slice", nil, []int(nil), []int(nil), ""},
+		{"undefined to map", Undefined{}, map[string]int(nil), map[string]int(nil), ""},
+		{"null to int", nil, 0, nil, "cannot use null as int"},
+		{"array to slice", []any{int64(1), 2.0}, []int(nil), []int{1, 2}, ""},
+		{"array with bad item", []any{int64(1), "two"}, []int(nil), nil, "item 1: cannot use string as int"},
+		{"object to map", map[string]any{"a": int64(1)}, map[string]float64(nil), map[string]float64{"a": 1}, ""},
+		{"object with bad item", map[string]any{"a": "x"}, map[string]int(nil), nil, "`a`: cannot use string as int"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			converted, err := convertArgument(c.value, reflect.TypeOf(c.target))
+			if c.err != "" {
+				if err == nil || err.Error() != c.err {
+					t.Fatalf("expected error %q, got %v", c.err, err)
+				}
+				return
+			} else if err != nil {
+				t.Fatalf("unexpected error: %s", err.Error())
+			}
+
+			if !reflect.DeepEqual(converted.Interface(), c.expected) {
+				t.Errorf("expected %#v, got %#v", c.expected, converted.Interface())
+			}
+		})
+	}
+}
+
+func TestGoFuncCall(t *testing.T) {
+	join := func(sep string, parts ...int) string {
+		items := make([]string, len(parts))
+		for i, part := range parts {
+			items[i] = fmt.Sprint(part)
+		}
+		return strings.Join(items, sep)
+	}
+	half := func(n int) (int, error) {
+		if n%2 != 0 {
+			return 0, fmt.Errorf("%d is odd", n)
+		}
+		return n / 2, nil
+	}
+
+	cases := []struct {
+		name     string
+		fn       any
+		args     Arguments
+		expected any
+		err      string
+	}{
+		{"variadic", join, Arguments{Positional: []any{"-", int64(1), 2.0, stdjson.Number("3")}}, "1-2-3", ""},
+		{"variadic without rest", join, Arguments{Positional: []any{"-"}}, "", ""},
+		{"too few variadic arguments", join, Arguments{}, nil, "expects at least 1 arguments, got 0"},
+		{"result and error", half, Arguments{Positional: []any{int64(4)}}, 2, ""},
+		{"returned error", half, Arguments{Positional: []any{int64(3)}}, nil, "3 is odd"},
+		{"wrong argument count", half, Arguments{Positional: []any{int64(1), int64(2)}}, nil, "expects 1 arguments, got 2"},
+		{"bad argument", half, Arguments{Positional: []any{"four"}}, nil, "argument 1: cannot use string as int"},
+		{"named arguments", half, Arguments{Named: map[string]any{"n": int64(4)}}, nil, "named arguments are not supported"},
+		{"only an error", func() error { return nil }, Arguments{}, nil, ""},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			goFn, err := NewGoFunc(c.fn)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			result, err := goFn.Call(c.args)
+			if c.err != "" {
+				if err == nil || err.Error() != c.err {
+					t.Fatalf("expected error %q, got %v", c.err, err)
+				}
+				return
+			} else if err != nil {
+				t.Fatalf("unexpected error: %s", err.Error())
+			}
+
+			if !reflect.DeepEqual(result, c.expected) {
+				t.Errorf("expected %#v, got %#v", c.expected, result)
+			}
+		})
+	}
+}
+
+func TestNewGoFuncErrors(t *testing.T) {
+	cases := []struct {
+		name string
+		fn   any
+		err  string
+	}{
+		{"not a function", "upper", "expected a function, got string"},
+		{"second result", func() (int, int) { return 0, 0 }, "second result of func() (int, int) should be an error"},
+		{"no results", func() {}, "func() should return a value, an error, or both"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if _, err := NewGoFunc(c.fn); err == nil || err.Error() != c.err {
+				t.Errorf("expected error %q, got %v", c.err, err)
+			}
+		})
+	}
+}
+
+func TestRegisterGoFilter(t *testing.T) {
+	testApp, err := newTwigApp(twigFile{"page.twig", "{{ name|repeat(3) }}{{ pad(7, 3) }}"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := testApp.RegisterGoFilter("repeat", strings.Repeat); err != nil {
+		t.Fatal(err)
+	}
+	if err := testApp.RegisterGoFunction("pad", func(n, width int) string {
+		return fmt.Sprintf("%0*d", width, n)
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := testApp.RegisterGoFilter("now", func() int { return 0 }); err == nil {
+		t.Error("expected a filter without parameters to be rejected")
+	}
+
+	output, err := testApp.Render("page", map[string]any{"name": "ab"})
+	if err != nil {
+		t.Fatal(err)
+	} else if output != "ababab007" {
+		t.Errorf("expected %q, got %q", "ababab007", output)
+	}
+}
@@ -61,12 +61,17 @@ func (ftl *FileTemplateLoader) LoadFromEngine(fileName string, input string) err
 		return err
 	}
 
-	return ftl.Store.Add(&Template{
+	template := &Template{
 		Name:     templateName,
 		blocks:   make(map[string][]Node),
 		Version:  "1",
 		RootNode: rootNode,
-	})
+	}
+
+	if reporter, ok := foundEngine.(engines.TruthinessReporter); ok {
+		template.Truthiness = TruthinessProfile(reporter.Truthiness())
+	}
+	return ftl.Store.Add(template)
 }
 
 func (*FileTemplateLoader) String() string {
@@ -90,8 +95,20 @@ type App struct {
 	DefaultTemplateName string
 	OutputPath          string
 	Templates           TemplateStore
-	Filters             map[string]FilterFunc
-	Functions           map[string]FunctionFunc
+	Filters             map[string]Filter
+	Functions           map[string]Function
+	Globals             Globals
+	MaxIncludeDepth     int
+	Undefined           UndefinedPolicy
+	Formatter           Formatter
+	// CallMethods allows templates to call the methods of Go values passed
+	// as context data, either as attributes or with arguments.
+	CallMethods bool
+}
+
+// RenderOptions overrides the settings of the App for a single render.
+type RenderOptions struct {
+	Undefined UndefinedPolicy
 }
 
 func (app *App) SaveOutput(data string) error {
@@ -119,17 +136,34 @@ func (app *App) SaveOutput(data string) error {
 	return nil
 }
 
-func (app *App) Render(templateName string, varData map[string]any) (string, error) {
+// Render renders the template with the given context data, which is either a
+// map or a Go value such as a struct or a pointer to one.
+func (app *App) Render(templateName string, varData any) (string, error) {
+	return app.RenderWithOptions(templateName, varData, RenderOptions{
+		Undefined: app.Undefined,
+	})
+}
+
+func (app *App) RenderWithOptions(templateName string, varData any, options RenderOptions) (string, error) {
+	if varData == nil {
+		varData = map[string]any{}
+	}
+
 	data := TemplateData{
 		Context: ContextData{
-			Data: varData,
+			Data:    varData,
+			Globals: app.Globals,
 		},
-		Filters:   app.Filters,
-		Functions: app.Functions,
-		Templates: app.Templates,
+		Filters:         app.Filters,
+		Functions:       app.Functions,
+		Templates:       app.Templates,
+		MaxIncludeDepth: app.MaxIncludeDepth,
+		CallMethods:     app.CallMethods,
+		Undefined:       options.Undefined,
+		Formatter:       app.Formatter,
 	}
 	writer := &bytes.Buffer{}
-	if err := app.Templates.Render(templateName, data, &simpleRenderer{writer: writer}); err != nil {
+	if err := app.Templates.Render(templateName, data, &simpleRenderer{writer: writer, formatter: app.Formatter}); err != nil {
 		return "", err
 	}
 	return writer.String(), nil
@@ -139,30 +173,111 @@ func (rnd *App) RegisterFilter(name string, filterFn FilterFunc) {
 	rnd.Filters[name] = filterFn
 }
 
+// RegisterFilterArgs registers a filter that receives the positional and
+// named arguments it is called with.
+func (rnd *App) RegisterFilterArgs(name string, filterFn FilterArgsFunc) {
+	rnd.Filters[name] = filterFn
+}
+
 func (rnd *App) RegisterFunction(name string, fnFn FunctionFunc) {
 	rnd.Functions[name] = fnFn
 }
 
+// RegisterGoFunction registers any Go function, such as
+// `func(s string, n int) string`, as a function. The arguments of the
+// function node are converted to the types of its parameters.
+func (rnd *App) RegisterGoFunction(name string, fn any) error {
+	goFn, err := NewGoFunc(fn)
+	if err != nil {
+		return fmt.Errorf("cannot register `%s` function: %s", name, err.Error())
+	}
+	rnd.Functions[name] = goFn
+	return nil
+}
+
+// RegisterGoFilter registers any Go function as a filter. The value to
+// filter is passed as the first argument.
+func (rnd *App) RegisterGoFilter(name string, fn any) error {
+	goFn, err := NewGoFunc(fn)
+	if err != nil {
+		return fmt.Errorf("cannot register `%s` filter: %s", name, err.Error())
+	} else if goFn.fnType.NumIn() == 0 {
+		return fmt.Errorf("cannot register `%s` filter: %s should accept the value to filter", name, goFn.fnType)
+	}
+	rnd.Filters[name] = goFn
+	return nil
+}
+
+func (rnd *App) RegisterGlobal(name string, value any) {
+	rnd.Globals[name] = value
+}
+
+// DecodeContextData decodes JSON context data. With exactNumbers, numbers
+// are decoded as json.Number which operators, filters and the renderer
+// handle without losing precision.
+func DecodeContextData(rawContextData []byte, exactNumbers bool) (map[string]any, error) {
+	decoder := json
+	if exactNumbers {
+		decoder = exactJson
+	}
+
+	contextData := make(map[string]any)
+	if err := decoder.Unmarshal(rawContextData, &contextData); err != nil {
+		return nil, err
+	}
+	return contextData, nil
+}
+
 var dataPath string
+var globalsPath string
+var exactNumbers bool
 var app = &App{
 	DefaultTemplateName: "default",
 	Templates:           TemplateStore{},
-	Filters:             map[string]FilterFunc{},
-	Functions:           map[string]FunctionFunc{},
+	Filters:             map[string]Filter{},
+	Functions:           map[string]Function{},
+	Globals:             Globals{},
+	MaxIncludeDepth:     DEFAULT_MAX_INCLUDE_DEPTH,
+	Undefined:           UNDEFINED_STRICT,
+	Formatter: Formatter{
+		BoolStyle:       BOOL_STYLE_WORDS,
+		CollectionStyle: COLLECTION_STYLE_JOIN,
+		Separator:       ", ",
+	},
 }
 
 var rootCmd = &cobra.Command{
 	Use:   "hulma",
 	Short: "Hulma is an experimental template compiler.",
 	RunE: func(cmd *cobra.Command, args []string) error {
+		if len(globalsPath) != 0 {
+			fullGlobalsPath, _ := filepath.Abs(globalsPath)
+			rawGlobals, err := os.ReadFile(fullGlobalsPath)
+			if err != nil {
+				return err
+			}
+
+			globals, err := DecodeContextData(rawGlobals, exactNumbers)
+			if err != nil {
+				return err
+			}
+
+			// globals passed as flags take precedence over the file
+			for name, value := range globals {
+				if _, exists := app.Globals[name]; !exists {
+					app.RegisterGlobal(name, value)
+				}
+			}
+		}
+
 		fullDataPath, _ := filepath.Abs(dataPath)
 		rawContextData, err := os.ReadFile(fullDataPath)
 		if err != nil {
 			return err
 		}
 
-		contextData := make(map[string]any)
-		if err := json.Unmarshal(rawContextData, &contextData); err != nil {
+		contextData, err := DecodeContextData(rawContextData, exactNumbers)
+		if err != nil {
 			return err
 		}
 
@@ -190,6 +305,15 @@ func init() {
 	rootCmd.PersistentFlags().Var(fileTemplateLoader, "template", "Path to the template.json file.")
 	rootCmd.PersistentFlags().Var(&app.Templates, "templateData", "JSON data of the template.")
 	rootCmd.PersistentFlags().StringVar(&dataPath, "data", "", "Path to the data.json file.")
+	rootCmd.PersistentFlags().Var(app.Globals, "global", "Global variable visible to every template, as key=value. Can be repeated.")
+	rootCmd.PersistentFlags().StringVar(&globalsPath, "globals", "", "Path to a JSON file of global variables.")
+	rootCmd.PersistentFlags().Var(&app.Undefined, "undefined", "How missing variables are handled: strict, lenient, chainable or debug.")
+	rootCmd.PersistentFlags().IntVar(&app.Formatter.FloatPrecision, "float-precision", 0, "Number of decimals of rendered floats. Zero uses the shortest representation.")
+	rootCmd.PersistentFlags().Var(&app.Formatter.BoolStyle, "bool-style", "How booleans are rendered: words or twig.")
+	rootCmd.PersistentFlags().Var(&app.Formatter.CollectionStyle, "collection-style", "How arrays and objects are rendered: join or json.")
+	rootCmd.PersistentFlags().StringVar(&app.Formatter.Separator, "separator", app.Formatter.Separator, "Separator of the items of rendered arrays and objects.")
+	rootCmd.PersistentFlags().BoolVar(&exactNumbers, "exact-numbers", false, "Decode the numbers of the data file without losing precision.")
+	rootCmd.PersistentFlags().IntVar(&app.MaxIncludeDepth, "max-include-depth", app.MaxIncludeDepth, "Maximum number of nested includes and macro calls. Zero means the default limit.")
 }
 
 func main() {
@@ -207,6 +331,26 @@ func main() {
 		return value, nil
 	})
 
+	app.RegisterFilterArgs("join", func(value any, args Arguments) (any, error) {
+		separator := ""
+		if len(args.Positional) != 0 {
+			separator = app.Formatter.Format(args.Positional[0])
+		} else if glue, exists := args.Named["glue"]; exists {
+			separator = app.Formatter.Format(glue)
+		}
+
+		_, items, err := iterate(value)
+		if err != nil {
+			return nil, err
+		}
+
+		itemStrs := make([]string, len(items))
+		for i, item := range items {
+			itemStrs[i] = app.Formatter.Format(item)
+		}
+		return strings.Join(itemStrs, separator), nil
+	})
+
 	app.RegisterFunction("foo", func(arguments any) (any, error) {
 		return "foo", nil
 	})
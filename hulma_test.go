@@ -0,0 +1,61 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/nedpals/hulma/engines"
+)
+
+type twigFile struct {
+	name   string
+	source string
+}
+
+// newTwigApp loads the Twig templates into an App using the filters,
+// functions and formatting of the default one.
+func newTwigApp(files ...twigFile) (*App, error) {
+	testApp := &App{
+		Templates:       TemplateStore{},
+		Filters:         app.Filters,
+		Functions:       app.Functions,
+		Globals:         Globals{},
+		MaxIncludeDepth: 16,
+		Undefined:       UNDEFINED_STRICT,
+		Formatter:       app.Formatter,
+	}
+
+	loader := &FileTemplateLoader{Engines: engines.Engines{engines.Twig{}}, Store: testApp.Templates}
+	for _, file := range files {
+		if err := loader.LoadFromEngine(file.name, file.source); err != nil {
+			return nil, err
+		}
+	}
+	return testApp, nil
+}
+
+type renderCase struct {
+	name     string
+	files    []twigFile
+	data     any
+	expected string
+}
+
+// testRender renders the `page` template of each case.
+func testRender(t *testing.T, cases []renderCase) {
+	t.Helper()
+
+	for _, c := range cases {
+		testApp, err := newTwigApp(c.files...)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", c.name, err)
+			continue
+		}
+
+		output, err := testApp.Render("page", c.data)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", c.name, err)
+		} else if output != c.expected {
+			t.Errorf("%s: expected %q, got %q", c.name, c.expected, output)
+		}
+	}
+}
@@ -0,0 +1,26 @@
+package main
+
+// Lazy is a context value that is only computed when a template reads the
+// variable holding it. The result is reused for the rest of the render and
+// an error fails the render.
+//
+// Plain `func() (any, error)` and `func() any` values are treated the same
+// way.
+type Lazy func() (any, error)
+
+// resolveLazy calls the value if it is a lazy provider. The second result
+// reports whether it was one.
+func resolveLazy(value any) (any, bool, error) {
+	switch provider := value.(type) {
+	case Lazy:
+		resolved, err := provider()
+		return resolved, true, err
+	case func() (any, error):
+		resolved, err := provider()
+		return resolved, true, err
+	case func() any:
+		return provider(), true, nil
+	default:
+		return value, false, nil
+	}
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestLazyValues(t *testing.T) {
+	testApp, err := newTwigApp(
+		twigFile{"page.twig", "{{ count }}{{ count }}{% include 'part' %}|{{ name }}"},
+		twigFile{"part.twig", "{{ count }}"},
+		twigFile{"unused.twig", "nothing"},
+		twigFile{"failing.twig", "{{ broken }}"},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	calls := 0
+	data := map[string]any{
+		"count": Lazy(func() (any, error) {
+			calls++
+			return calls, nil
+		}),
+		"name":   func() any { return "Ned" },
+		"broken": func() (any, error) { return nil, fmt.Errorf("cannot load") },
+	}
+
+	if output, err := testApp.Render("page", data); err != nil || output != "111|Ned" {
+		t.Errorf("expected the value to be computed once, got %q, %v", output, err)
+	}
+
+	if _, err := testApp.Render("unused", data); err != nil || calls != 1 {
+		t.Errorf("expected the unused value not to be computed, got %d calls, %v", calls, err)
+	}
+
+	if output, err := testApp.Render("part", data); err != nil || output != "2" {
+		t.Errorf("expected each render to compute the value again, got %q, %v", output, err)
+	}
+
+	if _, err := testApp.Render("failing", data); err == nil || err.Error() != "cannot resolve `broken`: cannot load" {
+		t.Errorf("expected the error of the value to fail the render, got %v", err)
+	}
+}
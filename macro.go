@@ -0,0 +1,171 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	types "github.com/nedpals/hulma/node_types"
+)
+
+// defaultSlotName is the name of a call slot without a name.
+const defaultSlotName = "default"
+
+type macroParameter struct {
+	name         string
+	defaultValue *Node
+}
+
+type Macro struct {
+	Name       string
+	template   *Template
+	parameters []macroParameter
+	body       []Node
+}
+
+func newMacro(node Node, tmpl *Template) (*Macro, error) {
+	macro := &Macro{
+		Name:     node.Value,
+		template: tmpl,
+	}
+
+	for _, cn := range node.Children {
+		switch types.MacroNodeType(cn.Type) {
+		case types.NODE_TYPE_MACRO_PARAMETER:
+			if len(cn.Value) == 0 {
+				return nil, fmt.Errorf("`%s` macro parameter should have a name", macro.Name)
+			} else if len(cn.Children) > 1 {
+				return nil, fmt.Errorf("`%s` macro parameter should have at most one default value", macro.Name)
+			}
+
+			param := macroParameter{name: cn.Value}
+			if len(cn.Children) == 1 {
+				param.defaultValue = &cn.Children[0]
+			}
+			macro.parameters = append(macro.parameters, param)
+		case types.NODE_TYPE_MACRO_BODY:
+			macro.body = cn.Children
+		default:
+			return nil, fmt.Errorf("invalid macro node: unexpected `%s` node", cn.Type)
+		}
+	}
+
+	return macro, nil
+}
+
+// findMacro looks for the macro in the template it is called from, then in
+// the templates being rendered (innermost first). A name such as
+// `forms.input` refers to the `input` macro of the `forms` template.
+func (tmpl TemplateData) findMacro(name string) (*Macro, error) {
+	if tmpl.current != nil {
+		if macro, exists := tmpl.current.macros[name]; exists {
+			return macro, nil
+		}
+	}
+
+	for i := len(tmpl.stack) - 1; i >= 0; i-- {
+		if renderedTemplate, exists := tmpl.Templates[tmpl.stack[i]]; exists {
+			if macro, exists := renderedTemplate.macros[name]; exists {
+				return macro, nil
+			}
+		}
+	}
+
+	if sepIdx := strings.LastIndex(name, "."); sepIdx != -1 {
+		templateName, macroName := name[:sepIdx], name[sepIdx+1:]
+		if macroTemplate, exists := tmpl.Templates[templateName]; !exists {
+			return nil, fmt.Errorf("template `%s` does not exist", templateName)
+		} else if macro, exists := macroTemplate.macros[macroName]; exists {
+			return macro, nil
+		}
+	}
+
+	return nil, fmt.Errorf("macro `%s` does not exist", name)
+}
+
+func (node Node) evaluateCall(tmpl TemplateData, renderer Renderer) error {
+	macro, err := tmpl.findMacro(node.Value)
+	if err != nil {
+		return err
+	} else if maxDepth := tmpl.maxIncludeDepth(); tmpl.callDepth >= maxDepth {
+		return fmt.Errorf("maximum macro call depth of %d exceeded while calling `%s`", maxDepth, node.Value)
+	}
+
+	args := make(map[string]any, len(macro.parameters))
+	slots := make(map[string][][]Node)
+	positional := 0
+
+	for _, cn := range node.Children {
+		switch types.MacroNodeType(cn.Type) {
+		case types.NODE_TYPE_CALL_ARGUMENT:
+			if len(cn.Children) != 1 {
+				return fmt.Errorf("call argument should have exactly one child")
+			}
+
+			name := cn.Value
+			if len(name) == 0 {
+				if positional >= len(macro.parameters) {
+					return fmt.Errorf("`%s` macro expects at most %d arguments", macro.Name, len(macro.parameters))
+				}
+				name = macro.parameters[positional].name
+				positional++
+			} else if !macro.hasParameter(name) {
+				return fmt.Errorf("`%s` macro has no `%s` parameter", macro.Name, name)
+			}
+
+			if _, exists := args[name]; exists {
+				return fmt.Errorf("`%s` argument is passed more than once to the `%s` macro", name, macro.Name)
+			}
+
+			value, err := cn.Children[0].evaluateExpression(tmpl)
+			if err != nil {
+				return err
+			}
+			args[name] = value
+		case types.NODE_TYPE_CALL_SLOT:
+			name := cn.Value
+			if len(name) == 0 {
+				name = defaultSlotName
+			}
+
+			// slots are rendered with the caller's context
+			rendered, err := renderToString(cn.Children, tmpl)
+			if err != nil {
+				return err
+			}
+			slots[name] = [][]Node{{{Type: types.NodeType(types.NODE_TYPE_CONTENT), Value: rendered}}}
+		default:
+			return fmt.Errorf("invalid call node: unexpected `%s` node", cn.Type)
+		}
+	}
+
+	// macros only see their own arguments and the globals
+	tmpl.Context = tmpl.Context.isolate(slots, args).newScope()
+	tmpl.current = macro.template
+	tmpl.callDepth++
+
+	for _, param := range macro.parameters {
+		if _, exists := args[param.name]; exists {
+			continue
+		} else if param.defaultValue == nil {
+			args[param.name] = nil
+			continue
+		}
+
+		value, err := param.defaultValue.evaluateExpression(tmpl)
+		if err != nil {
+			return err
+		}
+		args[param.name] = value
+	}
+
+	return renderChildren(macro.body, tmpl, renderer)
+}
+
+func (macro *Macro) hasParameter(name string) bool {
+	for _, param := range macro.parameters {
+		if param.name == name {
+			return true
+		}
+	}
+	return false
+}
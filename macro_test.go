@@ -0,0 +1,37 @@
+package main
+
+import "testing"
+
+func TestMacroCallDepth(t *testing.T) {
+	store := TemplateStore{}
+	err := store.Set(`{
+		"name": "page",
+		"root_node": {
+			"type": "source",
+			"children": [
+				{
+					"type": "macro",
+					"value": "again",
+					"children": [{ "type": "macro_body", "children": [{ "type": "call", "value": "again" }] }]
+				},
+				{ "type": "call", "value": "again" }
+			]
+		}
+	}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the zero value App has no limit configured, so the default applies
+	testApp := &App{Templates: store}
+	expected := "maximum macro call depth of 64 exceeded while calling `again`"
+	if _, err := testApp.Render("page", nil); err == nil || err.Error() != expected {
+		t.Errorf("expected %q, got %v", expected, err)
+	}
+
+	testApp.MaxIncludeDepth = 8
+	expected = "maximum macro call depth of 8 exceeded while calling `again`"
+	if _, err := testApp.Render("page", nil); err == nil || err.Error() != expected {
+		t.Errorf("expected %q, got %v", expected, err)
+	}
+}
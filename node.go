@@ -2,6 +2,10 @@ package main
 
 import (
 	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
 
 	types "github.com/nedpals/hulma/node_types"
 )
@@ -18,114 +22,98 @@ func (node Node) evaluateExpression(tmpl TemplateData) (any, error) {
 	case types.NODE_TYPE_CONTENT:
 		return node.Value, nil
 	case types.NODE_TYPE_VARIABLE:
-		gotValue, varExists := tmpl.Context.Data[node.Value]
-		if !varExists {
-			return nil, fmt.Errorf("variable `%s` does not exist", node.Value)
+		gotValue, varExists, err := tmpl.Context.lookup(node.Value, tmpl.CallMethods)
+		if err != nil {
+			return nil, fmt.Errorf("cannot resolve `%s`: %w", node.Value, err)
+		} else if !varExists {
+			return tmpl.undefined(&UndefinedError{Path: node.Value, Name: node.Value})
 		}
 		return gotValue, nil
+	case types.NODE_TYPE_ATTRIBUTE, types.NODE_TYPE_INDEX:
+		return node.evaluateAccess(tmpl)
+	case types.NODE_TYPE_SLICE:
+		return node.evaluateSlice(tmpl)
+	case types.NODE_TYPE_BINARY:
+		return node.evaluateBinary(tmpl)
+	case types.NODE_TYPE_UNARY:
+		return node.evaluateUnary(tmpl)
+	case types.NODE_TYPE_TERNARY:
+		return node.evaluateTernary(tmpl)
+	case types.NODE_TYPE_PARENT:
+		return evaluateParentBlock(tmpl)
+	case types.NODE_TYPE_TEST:
+		return node.evaluateTest(tmpl)
+	case types.NODE_TYPE_DEFAULT:
+		return node.evaluateDefault(tmpl)
+	case types.NODE_TYPE_NUMBER, types.NODE_TYPE_BOOLEAN, types.NODE_TYPE_NULL, types.NODE_TYPE_ARRAY, types.NODE_TYPE_HASH:
+		return node.evaluateLiteral(tmpl)
 	case types.NODE_TYPE_FILTER:
-		filterFn, filterExists := tmpl.Filters[node.Value]
-		if !filterExists {
-			return nil, fmt.Errorf("filter `%s` does not exist", node.Value)
-		}
-
-		evaluatedValue, err := node.Children[0].evaluateExpression(tmpl)
-		if err != nil {
-			return "", err
-		}
-
-		return filterFn(evaluatedValue)
+		return node.evaluateFilter(tmpl)
 	case types.NODE_TYPE_FUNCTION:
-		functionFn, functionExists := tmpl.Functions[node.Value]
-		if !functionExists {
-			filterFn, filterExists := tmpl.Filters[node.Value]
-			if filterExists && len(node.Children) == 1 {
-				functionFn = filterFn.ToFunction()
-			} else {
-				return nil, fmt.Errorf("function `%s` does not exist", node.Value)
-			}
-		}
-
-		evaluatedValue, err := node.collectFunctionArguments(tmpl)
-		if err != nil {
-			return "", err
-		}
-
-		return functionFn(evaluatedValue)
+		return node.evaluateFunction(tmpl)
 	default:
 		return nil, fmt.Errorf("invalid expression type: %s", exprType)
 	}
 }
 
-func (node Node) collectFunctionArguments(tmpl TemplateData) (any, error) {
-	if node.Type != types.NodeType(types.NODE_TYPE_FUNCTION) {
-		return nil, fmt.Errorf("node is not a function call")
-	} else if len(node.Children) == 0 {
+func (node Node) evaluateLiteral(tmpl TemplateData) (any, error) {
+	switch types.ExpressionNodeType(node.Type) {
+	case types.NODE_TYPE_NUMBER:
+		// integers stay as int64, everything else is a float64
+		if intVal, err := strconv.ParseInt(node.Value, 10, 64); err == nil {
+			return intVal, nil
+		} else if floatVal, err := strconv.ParseFloat(node.Value, 64); err == nil {
+			return floatVal, nil
+		}
+		return nil, fmt.Errorf("invalid number: %s", node.Value)
+	case types.NODE_TYPE_BOOLEAN:
+		boolVal, err := strconv.ParseBool(node.Value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid boolean: %s", node.Value)
+		}
+		return boolVal, nil
+	case types.NODE_TYPE_NULL:
 		return nil, nil
-	}
-
-	j := 0
-	shouldBeMap := false
-	keys := []string{}
-	values := []any{}
-
-	for _, child := range node.Children {
-		fType := types.FunctionNodeType(child.Type)
-
-		switch fType {
-		case types.NODE_TYPE_FUNCTION_PARAMETER:
-			if shouldBeMap {
-				shouldBeMap = true
-			}
-			keys = append(keys, child.Value)
-			j++
-		case types.NODE_TYPE_FUNCTION_ARGUMENT:
-			if len(child.Children) != 0 && len(child.Value) != 0 {
-				return nil, fmt.Errorf("argument value should not be a content or an expression node at the same time")
+	case types.NODE_TYPE_ARRAY:
+		items := make([]any, 0, len(node.Children))
+		for _, cn := range node.Children {
+			item, err := cn.evaluateExpression(tmpl)
+			if err != nil {
+				return nil, err
 			}
-
-			if len(keys) > len(values) {
-				keys = append(keys, fmt.Sprintf("%d", j))
+			items = append(items, item)
+		}
+		return items, nil
+	case types.NODE_TYPE_HASH:
+		hash := make(map[string]any, len(node.Children))
+		for _, cn := range node.Children {
+			if types.HashNodeType(cn.Type) != types.NODE_TYPE_HASH_PAIR {
+				return nil, fmt.Errorf("invalid hash node: unexpected `%s` node", cn.Type)
 			}
 
-			if len(child.Children) != 0 {
-				evaluatedValue, err := node.Children[0].evaluateExpression(tmpl)
+			// a pair with two children has a computed key
+			key := cn.Value
+			valueNode := cn.Children
+			if len(cn.Children) == 2 {
+				computedKey, err := cn.Children[0].evaluateExpression(tmpl)
 				if err != nil {
 					return nil, err
 				}
-
-				values = append(values, evaluatedValue)
-			} else {
-				values = append(values, child.Value)
+				key = keyString(computedKey)
+				valueNode = cn.Children[1:]
+			} else if len(cn.Children) != 1 {
+				return nil, fmt.Errorf("hash pair should have one or two children")
 			}
-		default:
-			return nil, fmt.Errorf("invalid filter type: %s", fType)
-		}
-	}
 
-	if shouldBeMap {
-		parameters := map[string]any{}
-		for i := range values {
-			parameters[keys[i]] = values[i]
+			value, err := valueNode[0].evaluateExpression(tmpl)
+			if err != nil {
+				return nil, err
+			}
+			hash[key] = value
 		}
-		return parameters, nil
-	} else if len(values) == 1 {
-		return values[0], nil
-	}
-
-	return values, nil
-}
-
-func renderBool(value any) bool {
-	if value == nil {
-		return false
-	} else if boolVal, ok := value.(bool); ok {
-		return boolVal
-	} else if strVal, ok := value.(string); ok {
-		return len(strVal) != 0
-	} else {
-		// TODO: support for maps and arrays
-		return false
+		return hash, nil
+	default:
+		return nil, fmt.Errorf("invalid literal type: %s", node.Type)
 	}
 }
 
@@ -133,8 +121,8 @@ func (node Node) evaluateStatement(tmpl TemplateData, renderer Renderer) error {
 	stmtType := types.StatementNodeType(node.Type)
 	switch stmtType {
 	case types.NODE_TYPE_YIELD:
-		if gotBlock, blockExists := tmpl.Context.Blocks[node.Value]; blockExists {
-			return renderChildren(gotBlock, tmpl, renderer)
+		if _, blockExists := tmpl.Context.Blocks[node.Value]; blockExists {
+			return renderBlock(node.Value, 0, node.Children, tmpl, renderer)
 		} else {
 			return renderChildren(node.Children, tmpl, renderer)
 		}
@@ -151,7 +139,7 @@ func (node Node) evaluateStatement(tmpl TemplateData, renderer Renderer) error {
 			return err
 		}
 
-		evaluatedResult := renderBool(rawEvaluatedValue)
+		evaluatedResult := tmpl.isTruthy(rawEvaluatedValue)
 		if evaluatedResult {
 			return renderChildren(node.Children[1].Children, tmpl, renderer)
 		} else if len(node.Children) == 3 {
@@ -165,47 +153,473 @@ func (node Node) evaluateStatement(tmpl TemplateData, renderer Renderer) error {
 			return renderChildren(node.Children[3].Children, tmpl, renderer)
 		}
 	case types.NODE_TYPE_LOOP:
-		// for loop dissect
-		// index 0 -
+		return node.evaluateLoop(tmpl, renderer)
+	case types.NODE_TYPE_ASSIGN, types.NODE_TYPE_CAPTURE:
+		return node.evaluateAssign(tmpl)
+	case types.NODE_TYPE_SWITCH:
+		return node.evaluateSwitch(tmpl, renderer)
+	default:
+		return fmt.Errorf("invalid expression type: %s", stmtType)
+	}
+	return nil
+}
 
-		// copy old context data
-		oldContext := make(map[string]any)
-		for k, v := range tmpl.Context.Data {
-			oldContext[k] = v
+// iterate returns the keys and values of a loop iterable. Maps are
+// iterated in sorted key order so that the output stays deterministic.
+func iterate(value any) ([]any, []any, error) {
+	switch iterable := value.(type) {
+	case nil, Undefined:
+		return nil, nil, nil
+	case []any:
+		keys := make([]any, len(iterable))
+		for i := range iterable {
+			keys[i] = i
+		}
+		return keys, iterable, nil
+	case map[string]any:
+		mapKeys := make([]string, 0, len(iterable))
+		for k := range iterable {
+			mapKeys = append(mapKeys, k)
 		}
+		sort.Strings(mapKeys)
 
-		// make a new special variable
+		keys := make([]any, len(mapKeys))
+		values := make([]any, len(mapKeys))
+		for i, k := range mapKeys {
+			keys[i] = k
+			values[i] = iterable[k]
+		}
+		return keys, values, nil
+	}
 
-		// context["$$i"] = len()
+	rv := reflect.ValueOf(value)
+	for rv.Kind() == reflect.Pointer || rv.Kind() == reflect.Interface {
+		if rv.IsNil() {
+			return nil, nil, nil
+		}
+		rv = rv.Elem()
+	}
 
-		// newlyAssigned := make([]string, 10)
-		// for _, cn := range node.Children {
-		// 	if err := cn.render(writer, context, renderer); err != nil {
-		// 		return err
-		// 	}
-		// }
+	switch rv.Kind() {
+	case reflect.Slice, reflect.Array:
+		keys := make([]any, rv.Len())
+		values := make([]any, rv.Len())
+		for i := range keys {
+			keys[i] = i
+			values[i] = rv.Index(i).Interface()
+		}
+		return keys, values, nil
+	case reflect.Map:
+		mapKeys := rv.MapKeys()
+		sortMapKeys(mapKeys)
 
-		// for k, v := range oldContext {
-		// 	context[k] = v
-		// }
+		keys := make([]any, len(mapKeys))
+		values := make([]any, len(mapKeys))
+		for i, k := range mapKeys {
+			keys[i] = k.Interface()
+			values[i] = rv.MapIndex(k).Interface()
+		}
+		return keys, values, nil
 	default:
-		return fmt.Errorf("invalid expression type: %s", stmtType)
+		return nil, nil, fmt.Errorf("cannot iterate over %T", value)
+	}
+}
+
+func (node Node) evaluateLoop(tmpl TemplateData, renderer Renderer) error {
+	var iterable []Node
+	var body, alternative []Node
+	targets := []string{}
+
+	for _, cn := range node.Children {
+		switch types.LoopNodeType(cn.Type) {
+		case types.NODE_TYPE_LOOP_TARGET:
+			targets = append(targets, cn.Value)
+		case types.NODE_TYPE_LOOP_ITERABLE:
+			iterable = cn.Children
+		case types.NODE_TYPE_LOOP_BODY:
+			body = cn.Children
+		case types.NODE_TYPE_LOOP_ELSE:
+			alternative = cn.Children
+		default:
+			return fmt.Errorf("invalid loop node: unexpected `%s` node", cn.Type)
+		}
+	}
+
+	if len(iterable) != 1 {
+		return fmt.Errorf("invalid loop node: iterable should have exactly one child")
+	} else if len(targets) == 0 || len(targets) > 2 {
+		return fmt.Errorf("invalid loop node: expected one or two targets, got %d", len(targets))
+	}
+
+	rawIterable, err := iterable[0].evaluateExpression(tmpl)
+	if err != nil {
+		return err
+	}
+
+	if !tmpl.isTruthy(rawIterable) {
+		return renderChildren(alternative, tmpl, renderer)
+	}
+
+	var keys, values []any
+	if tmpl.truthiness().iteratesOnce(rawIterable) {
+		keys, values = []any{0}, []any{rawIterable}
+	} else if keys, values, err = iterate(rawIterable); err != nil {
+		return err
+	} else if len(values) == 0 {
+		return renderChildren(alternative, tmpl, renderer)
+	}
+
+	// the enclosing loop (if any) is exposed as `loop.parent`
+	parentLoop, _ := tmpl.Context.Get("loop")
+	tmpl.Context = tmpl.Context.newScope()
+
+	for i, value := range values {
+		if len(targets) == 2 {
+			tmpl.Context.Set(targets[0], keys[i])
+			tmpl.Context.Set(targets[1], value)
+		} else {
+			tmpl.Context.Set(targets[0], value)
+		}
+
+		tmpl.Context.Set("loop", map[string]any{
+			"index":     i + 1,
+			"index0":    i,
+			"revindex":  len(values) - i,
+			"revindex0": len(values) - i - 1,
+			"first":     i == 0,
+			"last":      i == len(values)-1,
+			"length":    len(values),
+			"parent":    parentLoop,
+		})
+
+		if err := renderChildren(body, tmpl, renderer); err != nil {
+			return err
+		}
 	}
+
 	return nil
 }
 
-func (node Node) scanBlock(parentBlockName string, blocks map[string][]Node) error {
-	if node.Type != types.NodeType(types.NODE_TYPE_BLOCK) {
-		return fmt.Errorf("[scanBlocks] node not a block")
-	} else if node.Value == parentBlockName {
-		return fmt.Errorf("`%s` block should not be recursive", node.Value)
+func (node Node) evaluateSwitch(tmpl TemplateData, renderer Renderer) error {
+	if len(node.Children) == 0 || types.SwitchNodeType(node.Children[0].Type) != types.NODE_TYPE_SWITCH_SUBJECT || len(node.Children[0].Children) != 1 {
+		return fmt.Errorf("invalid switch node: should start with a subject")
+	}
+
+	subject, err := node.Children[0].Children[0].evaluateExpression(tmpl)
+	if err != nil {
+		return err
+	}
+
+	var defaultBody []Node
+	hasDefault := false
+
+	for _, cn := range node.Children[1:] {
+		switch types.SwitchNodeType(cn.Type) {
+		case types.NODE_TYPE_SWITCH_CASE:
+			matched := false
+			var body []Node
+
+			for _, caseChild := range cn.Children {
+				switch types.SwitchNodeType(caseChild.Type) {
+				case types.NODE_TYPE_SWITCH_CASE_VALUE:
+					if len(caseChild.Children) != 1 {
+						return fmt.Errorf("switch case value should have exactly one child")
+					} else if matched {
+						continue
+					}
+
+					value, err := caseChild.Children[0].evaluateExpression(tmpl)
+					if err != nil {
+						return err
+					}
+					matched = valuesEqual(subject, value)
+				case types.NODE_TYPE_SWITCH_CASE_BODY:
+					body = caseChild.Children
+				default:
+					return fmt.Errorf("invalid switch case: unexpected `%s` node", caseChild.Type)
+				}
+			}
+
+			if matched {
+				return renderChildren(body, tmpl, renderer)
+			}
+		case types.NODE_TYPE_SWITCH_DEFAULT:
+			if hasDefault {
+				return fmt.Errorf("switch node should only have one default")
+			}
+			hasDefault = true
+			defaultBody = cn.Children
+		default:
+			return fmt.Errorf("invalid switch node: unexpected `%s` node", cn.Type)
+		}
+	}
+
+	return renderChildren(defaultBody, tmpl, renderer)
+}
+
+func (node Node) evaluateAssign(tmpl TemplateData) error {
+	if len(node.Value) == 0 {
+		return fmt.Errorf("%s node should have a variable name", node.Type)
+	}
+
+	assignScope := "local"
+	children := make([]Node, 0, len(node.Children))
+	for _, cn := range node.Children {
+		if types.AssignNodeType(cn.Type) != types.NODE_TYPE_ASSIGN_SCOPE {
+			children = append(children, cn)
+		} else if cn.Value == "global" || cn.Value == "nearest" || cn.Value == "local" {
+			assignScope = cn.Value
+		} else {
+			return fmt.Errorf("invalid assign scope: %s", cn.Value)
+		}
+	}
+
+	var value any
+	if types.StatementNodeType(node.Type) == types.NODE_TYPE_CAPTURE {
+		captured, err := renderToString(children, tmpl)
+		if err != nil {
+			return err
+		}
+		value = captured
+	} else if len(children) != 1 {
+		return fmt.Errorf("assign node should have exactly one expression")
 	} else {
-		for _, cn := range node.Children {
-			_ = cn.scanBlock(node.Value, blocks)
+		evaluatedValue, err := children[0].evaluateExpression(tmpl)
+		if err != nil {
+			return err
+		}
+		value = evaluatedValue
+	}
+
+	switch assignScope {
+	case "global":
+		tmpl.Context.SetGlobal(node.Value, value)
+	case "nearest":
+		tmpl.Context.SetNearest(node.Value, value)
+	default:
+		tmpl.Context.Set(node.Value, value)
+	}
+	return nil
+}
+
+func (node Node) evaluateInclude(tmpl TemplateData, renderer Renderer) error {
+	candidates := []string{}
+	if len(node.Value) != 0 {
+		candidates = append(candidates, node.Value)
+	}
+
+	var withVars map[string]any
+	isolated, ignoreMissing, ownBlocks := false, false, false
+	isEmbed := node.Type == types.NODE_TYPE_EMBED
+
+	for _, cn := range node.Children {
+		if isEmbed && cn.Type == types.NODE_TYPE_BLOCK {
+			// block overrides are collected below
+			continue
+		}
+
+		switch types.IncludeNodeType(cn.Type) {
+		case types.NODE_TYPE_INCLUDE_NAME, types.NODE_TYPE_INCLUDE_WITH:
+			if len(cn.Children) != 1 {
+				return fmt.Errorf("%s node should have exactly one child", cn.Type)
+			}
+
+			value, err := cn.Children[0].evaluateExpression(tmpl)
+			if err != nil {
+				return err
+			}
+
+			if types.IncludeNodeType(cn.Type) == types.NODE_TYPE_INCLUDE_WITH {
+				vars, ok := value.(map[string]any)
+				if !ok {
+					return fmt.Errorf("include variables should be an object, got %T", value)
+				}
+				withVars = vars
+			} else if name, ok := value.(string); ok {
+				candidates = append(candidates, name)
+			} else if names, ok := value.([]any); ok {
+				for _, rawName := range names {
+					name, ok := rawName.(string)
+					if !ok {
+						return fmt.Errorf("include template names should be strings, got %T", rawName)
+					}
+					candidates = append(candidates, name)
+				}
+			} else {
+				return fmt.Errorf("include template name should be a string or an array of strings, got %T", value)
+			}
+		case types.NODE_TYPE_INCLUDE_ONLY:
+			isolated = true
+		case types.NODE_TYPE_INCLUDE_IGNORE_MISSING:
+			ignoreMissing = true
+		case types.NODE_TYPE_INCLUDE_OWN_BLOCKS:
+			ownBlocks = true
+		default:
+			return fmt.Errorf("invalid include node: unexpected `%s` node", cn.Type)
+		}
+	}
+
+	if len(candidates) == 0 {
+		return fmt.Errorf("include node should have a template name")
+	}
+
+	// the first existing template from the candidates wins. A path such
+	// as `partials/card.twig` also matches the template it is loaded as.
+	templateName := ""
+	for _, name := range candidates {
+		if _, templateExists := tmpl.Templates[name]; templateExists {
+			templateName = name
+			break
+		} else if _, templateExists := tmpl.Templates[templateNameFromPath(name)]; templateExists {
+			templateName = templateNameFromPath(name)
+			break
+		}
+	}
+
+	if len(templateName) == 0 {
+		if ignoreMissing {
+			return nil
+		} else if len(candidates) == 1 {
+			return fmt.Errorf("template `%s` does not exist", candidates[0])
+		}
+		return fmt.Errorf("none of the templates `%s` exist", strings.Join(candidates, "`, `"))
+	}
+
+	if ownBlocks {
+		// the blocks of the including template do not apply
+		tmpl.Context.Blocks = map[string][][]Node{}
+		tmpl.Context.block = nil
+	}
+
+	if isEmbed {
+		embedded, err := node.scanEmbed()
+		if err != nil {
+			return err
+		}
+
+		// the overrides only apply to this render, siblings keep using
+		// the caller's blocks
+		tmpl.Context.Blocks = withOverrides(tmpl.Context.Blocks, embedded.blocks)
+	}
+
+	if isolated {
+		if withVars == nil {
+			withVars = map[string]any{}
+		}
+		tmpl.Context = tmpl.Context.isolate(tmpl.Context.Blocks, withVars)
+	} else if len(withVars) != 0 {
+		tmpl.Context = tmpl.Context.newScope()
+		for k, v := range withVars {
+			tmpl.Context.Set(k, v)
+		}
+	}
+
+	return tmpl.Templates.Render(templateName, tmpl, renderer)
+}
+
+// scan walks the whole node tree and collects the block definitions, the
+// parent layout and the statically known references to other templates.
+// Includes inside conditions, loops and switches are conditional: they are
+// not references since they may be what ends a recursion.
+func (node Node) scan(parentBlocks []string, conditional bool, tmpl *Template) error {
+	switch node.Type {
+	case types.NODE_TYPE_BLOCK:
+		for i, name := range parentBlocks {
+			if name == node.Value {
+				chain := append(append([]string{}, parentBlocks[i:]...), node.Value)
+				return fmt.Errorf("`%s` block should not be recursive (%s)", node.Value, strings.Join(chain, " -> "))
+			}
 		}
-		blocks[node.Value] = node.Children
+
+		if _, blockExists := tmpl.blocks[node.Value]; blockExists {
+			return fmt.Errorf("`%s` block is defined more than once", node.Value)
+		}
+		tmpl.blocks[node.Value] = node.Children
+		parentBlocks = append(parentBlocks[:len(parentBlocks):len(parentBlocks)], node.Value)
+	case types.NODE_TYPE_EXTENDS:
+		if len(tmpl.extends) != 0 {
+			return fmt.Errorf("template should only extend one template")
+		} else if len(node.Value) == 0 {
+			return fmt.Errorf("extends node should have a template name")
+		}
+		tmpl.extends = node.Value
+		tmpl.references = append(tmpl.references, node.Value)
+	case types.NODE_TYPE_INCLUDE:
+		if len(node.Value) != 0 && !conditional {
+			tmpl.references = append(tmpl.references, node.Value)
+		}
+	case types.NODE_TYPE_USE:
+		if len(node.Value) == 0 {
+			return fmt.Errorf("use node should have a template name")
+		}
+		tmpl.uses = append(tmpl.uses, node.Value)
+		tmpl.references = append(tmpl.references, node.Value)
+	case types.NODE_TYPE_EMBED:
+		// blocks of an embed node override the embedded template's
+		// blocks, not the ones of the template it is placed in
+		embedded, err := node.scanEmbed()
+		if err != nil {
+			return err
+		} else if conditional {
+			return nil
+		}
+
+		if len(node.Value) != 0 {
+			tmpl.references = append(tmpl.references, node.Value)
+		}
+		tmpl.references = append(tmpl.references, embedded.references...)
 		return nil
+	case types.NODE_TYPE_MACRO:
+		if len(node.Value) == 0 {
+			return fmt.Errorf("macro node should have a name")
+		} else if _, macroExists := tmpl.macros[node.Value]; macroExists {
+			return fmt.Errorf("`%s` macro is defined more than once", node.Value)
+		}
+
+		macro, err := newMacro(node, tmpl)
+		if err != nil {
+			return err
+		}
+		tmpl.macros[node.Value] = macro
+	}
+
+	switch types.StatementNodeType(node.Type) {
+	case types.NODE_TYPE_COND, types.NODE_TYPE_LOOP, types.NODE_TYPE_SWITCH:
+		conditional = true
+	}
+
+	for _, cn := range node.Children {
+		if err := cn.scan(parentBlocks, conditional, tmpl); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// scanEmbed collects the block overrides of an embed node.
+func (node Node) scanEmbed() (*Template, error) {
+	embedded := newTemplate()
+	embedded.macros = make(map[string]*Macro)
+
+	for _, cn := range node.Children {
+		if cn.Type != types.NODE_TYPE_BLOCK {
+			continue
+		} else if err := cn.scan(nil, false, embedded); err != nil {
+			return nil, err
+		}
+	}
+	return embedded, nil
+}
+
+// isAssignment reports whether the node is a statement holding an assign or
+// a capture node.
+func (node Node) isAssignment() bool {
+	if node.Type != types.NODE_TYPE_STATEMENT || len(node.Children) != 1 {
+		return false
 	}
+
+	stmtType := types.StatementNodeType(node.Children[0].Type)
+	return stmtType == types.NODE_TYPE_ASSIGN || stmtType == types.NODE_TYPE_CAPTURE
 }
 
 func (node Node) evaluate(tmpl TemplateData, renderer Renderer) error {
@@ -218,8 +632,8 @@ func (node Node) evaluate(tmpl TemplateData, renderer Renderer) error {
 		}
 	case types.NodeType(types.NODE_TYPE_CONTENT):
 		return renderer.Write(node.Value)
-	case types.NODE_TYPE_INCLUDE:
-		return tmpl.Templates.Render(node.Value, tmpl, renderer)
+	case types.NODE_TYPE_INCLUDE, types.NODE_TYPE_EMBED:
+		return node.evaluateInclude(tmpl, renderer)
 	case types.NODE_TYPE_DISPLAY:
 		if len(node.Children) != 1 {
 			return fmt.Errorf("display node should have exactly one child")
@@ -227,6 +641,11 @@ func (node Node) evaluate(tmpl TemplateData, renderer Renderer) error {
 		gotValue, err := node.Children[0].evaluateExpression(tmpl)
 		if err != nil {
 			return err
+		} else if undefinedValue, isUndefined := gotValue.(Undefined); isUndefined {
+			if tmpl.Undefined == UNDEFINED_DEBUG {
+				return renderer.Write("{{ " + undefinedValue.Path + " }}")
+			}
+			return nil
 		}
 		return renderer.Write(gotValue)
 	case types.NODE_TYPE_STATEMENT:
@@ -234,8 +653,10 @@ func (node Node) evaluate(tmpl TemplateData, renderer Renderer) error {
 			return fmt.Errorf("statement node should have exactly one child")
 		}
 		return node.Children[0].evaluateStatement(tmpl, renderer)
-	case types.NODE_TYPE_BLOCK:
+	case types.NODE_TYPE_BLOCK, types.NODE_TYPE_EXTENDS, types.NODE_TYPE_MACRO, types.NODE_TYPE_USE:
 		return nil
+	case types.NODE_TYPE_CALL:
+		return node.evaluateCall(tmpl, renderer)
 	case types.NODE_TYPE_COMMENT:
 		return nil
 	default:
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"testing"
+
+	types "github.com/nedpals/hulma/node_types"
+)
+
+func TestRenderTwigSet(t *testing.T) {
+	testRender(t, []renderCase{
+		{
+			name:     "accumulator",
+			files:    []twigFile{{"page.twig", "{% set total = 0 %}{% for i in [1, 2, 3] %}{% set total = total + i %}{% endfor %}{{ total }}"}},
+			expected: "6",
+		},
+		{
+			name:     "capture accumulator",
+			files:    []twigFile{{"page.twig", "{% set list %}a{% endset %}{% for i in [1, 2] %}{% set list %}{{ list }}{{ i }}{% endset %}{% endfor %}{{ list }}"}},
+			expected: "a12",
+		},
+		{
+			name:     "loop variable",
+			files:    []twigFile{{"page.twig", "{% for i in [1, 2] %}{% set last = i %}{% endfor %}{{ last ?? 'none' }}"}},
+			expected: "none",
+		},
+		{
+			name: "include",
+			files: []twigFile{
+				{"card.twig", "{% set total = 2 %}{{ total }}"},
+				{"page.twig", "{% set total = 1 %}{% include 'card.twig' %}{{ total }}"},
+			},
+			expected: "21",
+		},
+	})
+}
+
+func TestRenderLoop(t *testing.T) {
+	testRender(t, []renderCase{
+		{
+			name:     "metadata",
+			files:    []twigFile{{"page.twig", "{% for x in items %}{{ loop.index }}{{ loop.index0 }}{{ loop.revindex }}{{ loop.revindex0 }}{{ loop.first ? 'F' }}{{ loop.last ? 'L' }}/{{ loop.length }} {% endfor %}"}},
+			data:     map[string]any{"items": []any{"a", "b", "c"}},
+			expected: "1032F/3 2121/3 3210L/3 ",
+		},
+		{
+			name:     "keys and values",
+			files:    []twigFile{{"page.twig", "{% for k, v in user %}{{ k }}={{ v }};{% endfor %}{% for i, v in ['x', 'y'] %}{{ i }}{{ v }}{% endfor %}"}},
+			data:     map[string]any{"user": map[string]any{"name": "Ned", "age": 30}},
+			expected: "age=30;name=Ned;0x1y",
+		},
+		{
+			name:     "else",
+			files:    []twigFile{{"page.twig", "{% for x in items %}{{ x }}{% else %}empty{% endfor %}|{% for x in missing ?? [] %}{{ x }}{% else %}none{% endfor %}"}},
+			data:     map[string]any{"items": []any{}},
+			expected: "empty|none",
+		},
+		{
+			name:     "parent loop",
+			files:    []twigFile{{"page.twig", "{% for a in [1, 2] %}{% for b in [1, 2] %}{{ loop.parent.index }}{{ loop.index }} {% endfor %}{% endfor %}"}},
+			expected: "11 12 21 22 ",
+		},
+		{
+			name:     "scope",
+			files:    []twigFile{{"page.twig", "{% set x = 'outer' %}{% for x in [1, 2] %}{{ x }}{% endfor %}|{{ x }}|{{ loop ?? 'no loop' }}"}},
+			expected: "12|outer|no loop",
+		},
+		{
+			name:     "go values",
+			files:    []twigFile{{"page.twig", "{% for k, v in scores %}{{ k }}:{{ v }} {% endfor %}{% for tag in tags %}{{ tag }}{% endfor %}"}},
+			data:     map[string]any{"scores": map[int]string{10: "c", 2: "b"}, "tags": []string{"a", "b"}},
+			expected: "2:b 10:c ab",
+		},
+	})
+}
+
+// irNode builds a node of the IR for templates written in Go.
+func irNode(nodeType string, value string, children ...Node) Node {
+	return Node{Type: types.NodeType(nodeType), Value: value, Children: children}
+}
+
+func irAssign(nodeType string, name string, scope string, children ...Node) Node {
+	if len(scope) != 0 {
+		children = append([]Node{irNode("assign_scope", scope)}, children...)
+	}
+	return irNode("statement", "", irNode(nodeType, name, children...))
+}
+
+func irLoop(target string, iterable Node, body ...Node) Node {
+	return irNode("statement", "", irNode("loop", "",
+		irNode("loop_target", target),
+		irNode("loop_iterable", "", iterable),
+		irNode("loop_body", "", body...),
+	))
+}
+
+func irDisplay(name string) Node {
+	return irNode("display", "", irNode("variable", name))
+}
+
+func TestRenderAssign(t *testing.T) {
+	store := TemplateStore{}
+	part := &Template{Name: "part", RootNode: irNode("source", "",
+		irAssign("assign", "x", "", irNode("content", "part")),
+		irAssign("assign", "fromPart", "global", irNode("content", "leaked")),
+	)}
+	page := &Template{Name: "page", RootNode: irNode("source", "",
+		irAssign("assign", "x", "", irNode("content", "outer")),
+		irAssign("assign", "count", "", irNode("number", "0")),
+		irLoop("i", irNode("array", "", irNode("number", "1"), irNode("number", "2")),
+			irAssign("assign", "x", "", irNode("content", "loop")),
+			irAssign("assign", "kept", "global", irNode("variable", "i")),
+			irAssign("assign", "count", "nearest", irNode("binary", "+", irNode("variable", "count"), irNode("variable", "i"))),
+			irAssign("assign", "inner", "nearest", irNode("variable", "i")),
+			irDisplay("x"),
+		),
+		irNode("content", "|"), irDisplay("x"),
+		irNode("content", "|"), irDisplay("kept"),
+		irNode("content", "|"), irDisplay("count"),
+		irNode("content", "|"), irNode("display", "", irNode("default", "", irNode("variable", "inner"), irNode("content", "gone"))),
+		irAssign("capture", "title", "", irNode("content", "Hi "), irDisplay("x")),
+		irNode("content", "|"), irDisplay("title"),
+		irNode("include", "part"),
+		irNode("content", "|"), irDisplay("x"),
+		irNode("content", "|"), irDisplay("fromPart"),
+	)}
+
+	for _, template := range []*Template{part, page} {
+		if err := store.Add(template); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	testApp := &App{Templates: store}
+	expected := "looploop|outer|2|3|gone|Hi outer|outer|leaked"
+	if output, err := testApp.Render("page", nil); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	} else if output != expected {
+		t.Errorf("expected %q, got %q", expected, output)
+	}
+
+	invalid := &Template{Name: "invalid", RootNode: irNode("source", "", irAssign("assign", "x", "outer", irNode("number", "1")))}
+	if err := store.Add(invalid); err != nil {
+		t.Fatal(err)
+	} else if _, err := testApp.Render("invalid", nil); err == nil || err.Error() != "invalid assign scope: outer" {
+		t.Errorf("expected an invalid scope error, got %v", err)
+	}
+}
@@ -9,24 +9,64 @@ const (
 	NODE_TYPE_INCLUDE   NodeType = "include"
 	NODE_TYPE_BLOCK     NodeType = "block"
 	NODE_TYPE_COMMENT   NodeType = "comment"
+	NODE_TYPE_EXTENDS   NodeType = "extends"
+	NODE_TYPE_MACRO     NodeType = "macro"
+	NODE_TYPE_CALL      NodeType = "call"
+	NODE_TYPE_EMBED     NodeType = "embed"
+	NODE_TYPE_USE       NodeType = "use"
 )
 
 type ExpressionNodeType NodeType
 
 const (
-	NODE_TYPE_VARIABLE ExpressionNodeType = "variable"
-	NODE_TYPE_FILTER   ExpressionNodeType = "filter"
-	NODE_TYPE_CONTENT  ExpressionNodeType = "content"
-	NODE_TYPE_FUNCTION ExpressionNodeType = "function"
+	NODE_TYPE_VARIABLE  ExpressionNodeType = "variable"
+	NODE_TYPE_FILTER    ExpressionNodeType = "filter"
+	NODE_TYPE_CONTENT   ExpressionNodeType = "content"
+	NODE_TYPE_FUNCTION  ExpressionNodeType = "function"
+	NODE_TYPE_ATTRIBUTE ExpressionNodeType = "attribute"
+	NODE_TYPE_INDEX     ExpressionNodeType = "index"
+	NODE_TYPE_SLICE     ExpressionNodeType = "slice"
+	NODE_TYPE_BINARY    ExpressionNodeType = "binary"
+	NODE_TYPE_UNARY     ExpressionNodeType = "unary"
+	NODE_TYPE_TERNARY   ExpressionNodeType = "ternary"
+	NODE_TYPE_NUMBER    ExpressionNodeType = "number"
+	NODE_TYPE_BOOLEAN   ExpressionNodeType = "boolean"
+	NODE_TYPE_NULL      ExpressionNodeType = "null"
+	NODE_TYPE_ARRAY     ExpressionNodeType = "array"
+	NODE_TYPE_HASH      ExpressionNodeType = "hash"
+	NODE_TYPE_PARENT    ExpressionNodeType = "parent"
+	NODE_TYPE_TEST      ExpressionNodeType = "test"
+	NODE_TYPE_DEFAULT   ExpressionNodeType = "default"
+)
+
+type HashNodeType NodeType
+
+const (
+	NODE_TYPE_HASH_PAIR HashNodeType = "hash_pair"
+)
+
+type SliceNodeType NodeType
+
+const (
+	NODE_TYPE_SLICE_START  SliceNodeType = "slice_start"
+	NODE_TYPE_SLICE_LENGTH SliceNodeType = "slice_length"
 )
 
 type StatementNodeType NodeType
 
 const (
-	NODE_TYPE_COND   StatementNodeType = "cond"
-	NODE_TYPE_YIELD  StatementNodeType = "yield"
-	NODE_TYPE_LOOP   StatementNodeType = "loop"
-	NODE_TYPE_ASSIGN StatementNodeType = "assign"
+	NODE_TYPE_COND    StatementNodeType = "cond"
+	NODE_TYPE_YIELD   StatementNodeType = "yield"
+	NODE_TYPE_LOOP    StatementNodeType = "loop"
+	NODE_TYPE_ASSIGN  StatementNodeType = "assign"
+	NODE_TYPE_CAPTURE StatementNodeType = "capture"
+	NODE_TYPE_SWITCH  StatementNodeType = "switch"
+)
+
+type AssignNodeType NodeType
+
+const (
+	NODE_TYPE_ASSIGN_SCOPE AssignNodeType = "assign_scope"
 )
 
 type FunctionNodeType NodeType
@@ -43,3 +83,41 @@ const (
 	NODE_TYPE_COND_CONSEQ CondNodeType = "cond_consequence"
 	NODE_TYPE_COND_ALTER  CondNodeType = "cond_alternative"
 )
+
+type LoopNodeType NodeType
+
+const (
+	NODE_TYPE_LOOP_TARGET   LoopNodeType = "loop_target"
+	NODE_TYPE_LOOP_ITERABLE LoopNodeType = "loop_iterable"
+	NODE_TYPE_LOOP_BODY     LoopNodeType = "loop_body"
+	NODE_TYPE_LOOP_ELSE     LoopNodeType = "loop_else"
+)
+
+type IncludeNodeType NodeType
+
+const (
+	NODE_TYPE_INCLUDE_NAME           IncludeNodeType = "include_name"
+	NODE_TYPE_INCLUDE_WITH           IncludeNodeType = "include_with"
+	NODE_TYPE_INCLUDE_ONLY           IncludeNodeType = "include_only"
+	NODE_TYPE_INCLUDE_IGNORE_MISSING IncludeNodeType = "include_ignore_missing"
+	NODE_TYPE_INCLUDE_OWN_BLOCKS     IncludeNodeType = "include_own_blocks"
+)
+
+type MacroNodeType NodeType
+
+const (
+	NODE_TYPE_MACRO_PARAMETER MacroNodeType = "macro_parameter"
+	NODE_TYPE_MACRO_BODY      MacroNodeType = "macro_body"
+	NODE_TYPE_CALL_ARGUMENT   MacroNodeType = "call_argument"
+	NODE_TYPE_CALL_SLOT       MacroNodeType = "call_slot"
+)
+
+type SwitchNodeType NodeType
+
+const (
+	NODE_TYPE_SWITCH_SUBJECT    SwitchNodeType = "switch_subject"
+	NODE_TYPE_SWITCH_CASE       SwitchNodeType = "switch_case"
+	NODE_TYPE_SWITCH_CASE_VALUE SwitchNodeType = "switch_case_value"
+	NODE_TYPE_SWITCH_CASE_BODY  SwitchNodeType = "switch_case_body"
+	NODE_TYPE_SWITCH_DEFAULT    SwitchNodeType = "switch_default"
+)
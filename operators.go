@@ -0,0 +1,690 @@
+package main
+
+import (
+	stdjson "encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// toNumber converts an operand into either an int64 or a float64. Integers
+// are kept as int64 so that integer arithmetic stays exact. nil and
+// booleans count as 0 and 1, and strings must hold a valid number.
+func toNumber(value any) (any, bool) {
+	switch v := value.(type) {
+	case nil, Undefined:
+		return int64(0), true
+	case bool:
+		if v {
+			return int64(1), true
+		}
+		return int64(0), true
+	case int:
+		return int64(v), true
+	case int8:
+		return int64(v), true
+	case int16:
+		return int64(v), true
+	case int32:
+		return int64(v), true
+	case int64:
+		return v, true
+	case uint:
+		return toNumber(uint64(v))
+	case uint8:
+		return int64(v), true
+	case uint16:
+		return int64(v), true
+	case uint32:
+		return int64(v), true
+	case uint64:
+		// like PHP, integers beyond the int64 range become floats
+		if v > math.MaxInt64 {
+			return float64(v), true
+		}
+		return int64(v), true
+	case float32:
+		return float64(v), true
+	case float64:
+		return v, true
+	case stdjson.Number:
+		// numbers beyond the range of a float64 become infinite
+		if i, err := v.Int64(); err == nil {
+			return i, true
+		} else if f, err := v.Float64(); err == nil || errors.Is(err, strconv.ErrRange) {
+			return f, true
+		}
+	case string:
+		if i, err := strconv.ParseInt(strings.TrimSpace(v), 10, 64); err == nil {
+			return i, true
+		} else if f, err := strconv.ParseFloat(strings.TrimSpace(v), 64); err == nil || errors.Is(err, strconv.ErrRange) {
+			return f, true
+		}
+	}
+	return nil, false
+}
+
+// isNumeric reports whether the value is a Go numeric type. Unlike
+// toNumber, strings, booleans and nil are not considered numbers.
+func isNumeric(value any) bool {
+	switch value.(type) {
+	case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64, float32, float64, stdjson.Number:
+		return true
+	default:
+		return false
+	}
+}
+
+// asNumber converts a Go numeric value like toNumber, which fails for
+// anything else.
+func asNumber(value any) (any, bool) {
+	if !isNumeric(value) {
+		return nil, false
+	}
+	return toNumber(value)
+}
+
+func isExactNumber(value any) bool {
+	_, ok := value.(stdjson.Number)
+	return ok
+}
+
+// toRat converts a number into a rational number without losing the
+// precision of json.Number values. Floats are converted from their
+// shortest decimal representation.
+func toRat(value any) (*big.Rat, bool) {
+	if exactNum, ok := value.(stdjson.Number); ok {
+		return new(big.Rat).SetString(exactNum.String())
+	}
+
+	number, ok := toNumber(value)
+	if !ok {
+		return nil, false
+	} else if intNum, ok := number.(int64); ok {
+		return new(big.Rat).SetInt64(intNum), true
+	} else if floatNum := number.(float64); math.IsNaN(floatNum) || math.IsInf(floatNum, 0) {
+		return nil, false
+	} else {
+		return new(big.Rat).SetString(strconv.FormatFloat(floatNum, 'g', -1, 64))
+	}
+}
+
+// ratToNumber converts a rational number back into a json.Number. Results
+// without an exact decimal representation (such as 1/3) become floats.
+func ratToNumber(r *big.Rat) any {
+	if r.IsInt() {
+		return stdjson.Number(r.Num().String())
+	}
+
+	// a fraction has a finite decimal representation only if its
+	// denominator has no prime factors other than 2 and 5
+	denom := new(big.Int).Set(r.Denom())
+	twos, fives := 0, 0
+	for _, factor := range []int64{2, 5} {
+		divisor := big.NewInt(factor)
+		remainder := new(big.Int)
+		for {
+			quotient, rem := new(big.Int).QuoRem(denom, divisor, remainder)
+			if rem.Sign() != 0 {
+				break
+			}
+			denom = quotient
+			if factor == 2 {
+				twos++
+			} else {
+				fives++
+			}
+		}
+	}
+
+	if denom.Cmp(big.NewInt(1)) == 0 {
+		precision := twos
+		if fives > precision {
+			precision = fives
+		}
+		return stdjson.Number(r.FloatString(precision))
+	}
+
+	floatNum, _ := r.Float64()
+	return floatNum
+}
+
+// exactArithmetic is used when one of the operands is a json.Number so
+// that large integers and decimals keep their precision.
+func exactArithmetic(operator string, left, right any) (any, bool, error) {
+	leftRat, leftOk := toRat(left)
+	rightRat, rightOk := toRat(right)
+	if !leftOk || !rightOk {
+		return nil, false, nil
+	}
+
+	switch operator {
+	case "+":
+		return ratToNumber(new(big.Rat).Add(leftRat, rightRat)), true, nil
+	case "-":
+		return ratToNumber(new(big.Rat).Sub(leftRat, rightRat)), true, nil
+	case "*":
+		return ratToNumber(new(big.Rat).Mul(leftRat, rightRat)), true, nil
+	case "/", "//", "%":
+		if rightRat.Sign() == 0 {
+			return nil, true, fmt.Errorf("division by zero")
+		}
+
+		quotient := new(big.Rat).Quo(leftRat, rightRat)
+		if operator == "/" {
+			return ratToNumber(quotient), true, nil
+		}
+
+		// the denominator of a big.Rat is always positive, so Div
+		// (Euclidean division) floors and Quo truncates
+		var whole *big.Int
+		if operator == "//" {
+			whole = new(big.Int).Div(quotient.Num(), quotient.Denom())
+			return ratToNumber(new(big.Rat).SetInt(whole)), true, nil
+		}
+
+		whole = new(big.Int).Quo(quotient.Num(), quotient.Denom())
+		remainder := new(big.Rat).Sub(leftRat, new(big.Rat).Mul(rightRat, new(big.Rat).SetInt(whole)))
+		return ratToNumber(remainder), true, nil
+	default:
+		return nil, false, nil
+	}
+}
+
+func toFloat(number any) float64 {
+	if i, ok := number.(int64); ok {
+		return float64(i)
+	}
+	return number.(float64)
+}
+
+// intArithmetic applies the operator to two integers. Like PHP, results
+// that overflow an int64 are left to float arithmetic, which is also used
+// by the operators without an integer result.
+func intArithmetic(operator string, left, right int64) (any, bool, error) {
+	switch operator {
+	case "+":
+		sum := left + right
+		if (left > 0 && right > 0 && sum < 0) || (left < 0 && right < 0 && sum >= 0) {
+			return nil, false, nil
+		}
+		return sum, true, nil
+	case "-":
+		difference := left - right
+		if (right > 0 && difference > left) || (right < 0 && difference < left) {
+			return nil, false, nil
+		}
+		return difference, true, nil
+	case "*":
+		product := left * right
+		if left != 0 && (product/left != right || (left == -1 && right == math.MinInt64)) {
+			return nil, false, nil
+		}
+		return product, true, nil
+	case "//", "%":
+		if right == 0 {
+			return nil, true, fmt.Errorf("division by zero")
+		} else if operator == "%" {
+			return left % right, true, nil
+		} else if left == math.MinInt64 && right == -1 {
+			return nil, false, nil
+		}
+
+		quotient := left / right
+		if (left%right != 0) && ((left < 0) != (right < 0)) {
+			quotient--
+		}
+		return quotient, true, nil
+	default:
+		return nil, false, nil
+	}
+}
+
+func arithmetic(operator string, left, right any) (any, error) {
+	if isExactNumber(left) || isExactNumber(right) {
+		if result, ok, err := exactArithmetic(operator, left, right); ok {
+			return result, err
+		}
+	}
+
+	leftNum, leftOk := toNumber(left)
+	rightNum, rightOk := toNumber(right)
+	if !leftOk || !rightOk {
+		return nil, fmt.Errorf("unsupported operand types for %s: %T and %T", operator, left, right)
+	}
+
+	leftInt, leftIsInt := leftNum.(int64)
+	rightInt, rightIsInt := rightNum.(int64)
+	if leftIsInt && rightIsInt {
+		if result, ok, err := intArithmetic(operator, leftInt, rightInt); ok || err != nil {
+			return result, err
+		}
+	}
+
+	leftFloat, rightFloat := toFloat(leftNum), toFloat(rightNum)
+	switch operator {
+	case "+":
+		return leftFloat + rightFloat, nil
+	case "-":
+		return leftFloat - rightFloat, nil
+	case "*":
+		return leftFloat * rightFloat, nil
+	case "**":
+		return math.Pow(leftFloat, rightFloat), nil
+	case "/", "//", "%":
+		if rightFloat == 0 {
+			return nil, fmt.Errorf("division by zero")
+		} else if operator == "/" {
+			return leftFloat / rightFloat, nil
+		} else if operator == "//" {
+			return math.Floor(leftFloat / rightFloat), nil
+		}
+		return math.Mod(leftFloat, rightFloat), nil
+	default:
+		return nil, fmt.Errorf("invalid arithmetic operator: %s", operator)
+	}
+}
+
+// valuesEqual compares two values strictly: numbers are compared by value
+// regardless of their Go type, while values of different kinds (such as
+// "1" and 1) are never equal.
+func valuesEqual(left, right any) bool {
+	// undefined values compare like null
+	if _, ok := left.(Undefined); ok {
+		left = nil
+	}
+	if _, ok := right.(Undefined); ok {
+		right = nil
+	}
+
+	if isNumeric(left) && isNumeric(right) {
+		if result, ok := compareExact(left, right); ok {
+			return result == 0
+		}
+	}
+
+	leftNum, leftOk := asNumber(left)
+	rightNum, rightOk := asNumber(right)
+	if leftOk && rightOk {
+		leftInt, leftIsInt := leftNum.(int64)
+		rightInt, rightIsInt := rightNum.(int64)
+		if leftIsInt && rightIsInt {
+			return leftInt == rightInt
+		}
+		return toFloat(leftNum) == toFloat(rightNum)
+	}
+
+	switch l := left.(type) {
+	case nil:
+		return right == nil
+	case string:
+		r, ok := right.(string)
+		return ok && l == r
+	case bool:
+		r, ok := right.(bool)
+		return ok && l == r
+	default:
+		return reflect.DeepEqual(left, right)
+	}
+}
+
+// compareExact compares numbers exactly if one of them is a json.Number.
+func compareExact(left, right any) (int, bool) {
+	if !isExactNumber(left) && !isExactNumber(right) {
+		return 0, false
+	}
+
+	leftRat, leftOk := toRat(left)
+	rightRat, rightOk := toRat(right)
+	if !leftOk || !rightOk {
+		return 0, false
+	}
+	return leftRat.Cmp(rightRat), true
+}
+
+// compareValues orders numbers numerically and strings lexicographically.
+// Null and undefined values compare as 0, or as an empty string against a
+// string.
+func compareValues(left, right any) (int, error) {
+	left, right = nullOperand(left, right), nullOperand(right, left)
+
+	if isNumeric(left) && isNumeric(right) {
+		if result, ok := compareExact(left, right); ok {
+			return result, nil
+		}
+	}
+
+	leftNum, leftOk := asNumber(left)
+	rightNum, rightOk := asNumber(right)
+	if leftOk && rightOk {
+		leftInt, leftIsInt := leftNum.(int64)
+		rightInt, rightIsInt := rightNum.(int64)
+		if leftIsInt && rightIsInt {
+			if leftInt < rightInt {
+				return -1, nil
+			} else if leftInt > rightInt {
+				return 1, nil
+			}
+			return 0, nil
+		}
+
+		leftFloat, rightFloat := toFloat(leftNum), toFloat(rightNum)
+		if leftFloat < rightFloat {
+			return -1, nil
+		} else if leftFloat > rightFloat {
+			return 1, nil
+		}
+		return 0, nil
+	}
+
+	leftStr, leftIsStr := left.(string)
+	rightStr, rightIsStr := right.(string)
+	if leftIsStr && rightIsStr {
+		return strings.Compare(leftStr, rightStr), nil
+	}
+
+	return 0, fmt.Errorf("cannot compare %T with %T", left, right)
+}
+
+func nullOperand(value, other any) any {
+	switch value.(type) {
+	case nil, Undefined:
+		if _, otherIsStr := other.(string); otherIsStr {
+			return ""
+		}
+		return int64(0)
+	}
+	return value
+}
+
+// contains implements the `in` operator: substrings for strings, items for
+// arrays and keys for objects.
+func contains(container, item any) (bool, error) {
+	switch c := container.(type) {
+	case nil, Undefined:
+		return false, nil
+	case string:
+		itemStr, ok := item.(string)
+		if !ok {
+			return false, fmt.Errorf("`in <string>` requires a string as the left operand, got %T", item)
+		}
+		return strings.Contains(c, itemStr), nil
+	case []any:
+		for _, v := range c {
+			if valuesEqual(v, item) {
+				return true, nil
+			}
+		}
+		return false, nil
+	case map[string]any:
+		_, exists := c[keyString(item)]
+		return exists, nil
+	}
+
+	rv := reflect.ValueOf(container)
+	switch rv.Kind() {
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < rv.Len(); i++ {
+			if valuesEqual(rv.Index(i).Interface(), item) {
+				return true, nil
+			}
+		}
+		return false, nil
+	case reflect.Map:
+		_, found, err := lookupKey(container, item, false)
+		return found, err
+	default:
+		return false, fmt.Errorf("cannot check membership in %T", container)
+	}
+}
+
+func (node Node) evaluateBinary(tmpl TemplateData) (any, error) {
+	if len(node.Children) != 2 {
+		return nil, fmt.Errorf("binary node should have exactly two children")
+	}
+
+	left, err := node.Children[0].evaluateExpression(tmpl)
+
+	// operators that may not need the right operand
+	switch node.Value {
+	case "??":
+		if isUndefined(left, err) || (err == nil && left == nil) {
+			return node.Children[1].evaluateExpression(tmpl)
+		}
+		return left, err
+	case "?:":
+		if err != nil {
+			return nil, err
+		} else if tmpl.isTruthy(left) {
+			return left, nil
+		}
+		return node.Children[1].evaluateExpression(tmpl)
+	case "and", "or":
+		if err != nil {
+			return nil, err
+		} else if leftResult := tmpl.isTruthy(left); (node.Value == "and") != leftResult {
+			return leftResult, nil
+		}
+
+		right, err := node.Children[1].evaluateExpression(tmpl)
+		if err != nil {
+			return nil, err
+		}
+		return tmpl.isTruthy(right), nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	right, err := node.Children[1].evaluateExpression(tmpl)
+	if err != nil {
+		return nil, err
+	}
+
+	switch node.Value {
+	case "+", "-", "*", "/", "//", "%", "**":
+		return arithmetic(node.Value, left, right)
+	case "~":
+		return tmpl.Formatter.Format(left) + tmpl.Formatter.Format(right), nil
+	case "==":
+		return valuesEqual(left, right), nil
+	case "!=":
+		return !valuesEqual(left, right), nil
+	case "<", "<=", ">", ">=":
+		result, err := compareValues(left, right)
+		if err != nil {
+			return nil, err
+		}
+
+		switch node.Value {
+		case "<":
+			return result < 0, nil
+		case "<=":
+			return result <= 0, nil
+		case ">":
+			return result > 0, nil
+		default:
+			return result >= 0, nil
+		}
+	case "in", "not in":
+		found, err := contains(right, left)
+		if err != nil {
+			return nil, err
+		}
+		return found == (node.Value == "in"), nil
+	case "..":
+		return valueRange(left, right)
+	case "starts with":
+		return strings.HasPrefix(tmpl.Formatter.Format(left), tmpl.Formatter.Format(right)), nil
+	case "ends with":
+		return strings.HasSuffix(tmpl.Formatter.Format(left), tmpl.Formatter.Format(right)), nil
+	case "matches":
+		pattern, ok := right.(string)
+		if !ok {
+			return nil, fmt.Errorf("pattern of `matches` should be a string, got %T", right)
+		}
+
+		re, err := compilePattern(pattern)
+		if err != nil {
+			return nil, err
+		}
+		return re.MatchString(tmpl.Formatter.Format(left)), nil
+	case "b-and", "b-or", "b-xor":
+		leftInt, leftOk := toInteger(left)
+		rightInt, rightOk := toInteger(right)
+		if !leftOk || !rightOk {
+			return nil, fmt.Errorf("unsupported operand types for %s: %T and %T", node.Value, left, right)
+		}
+
+		switch node.Value {
+		case "b-and":
+			return leftInt & rightInt, nil
+		case "b-or":
+			return leftInt | rightInt, nil
+		default:
+			return leftInt ^ rightInt, nil
+		}
+	default:
+		return nil, fmt.Errorf("invalid binary operator: %s", node.Value)
+	}
+}
+
+// toInteger converts an operand into an int64 if it is a number without a
+// fractional part.
+func toInteger(value any) (int64, bool) {
+	number, ok := toNumber(value)
+	if !ok {
+		return 0, false
+	} else if intNum, isInt := number.(int64); isInt {
+		return intNum, true
+	} else if floatNum := number.(float64); floatNum == math.Trunc(floatNum) && math.Abs(floatNum) < math.MaxInt64 {
+		return int64(floatNum), true
+	}
+	return 0, false
+}
+
+var patternCache sync.Map // map[string]*regexp.Regexp
+
+// compilePattern compiles a regular expression written either in the Go
+// syntax or, like in PHP, between delimiters with trailing flags such as
+// `/^a.*z$/i`.
+func compilePattern(pattern string) (*regexp.Regexp, error) {
+	if cached, ok := patternCache.Load(pattern); ok {
+		return cached.(*regexp.Regexp), nil
+	}
+
+	expr := pattern
+	if delimiter, size := utf8.DecodeRuneInString(pattern); size != 0 && strings.ContainsRune("/#~!@%|+", delimiter) {
+		end := strings.LastIndex(pattern, string(delimiter))
+		if end <= 0 {
+			return nil, fmt.Errorf("pattern `%s` has no ending delimiter", pattern)
+		}
+
+		flags := pattern[end+1:]
+		for _, flag := range flags {
+			if !strings.ContainsRune("imsU", flag) {
+				return nil, fmt.Errorf("pattern `%s` has an unsupported flag `%c`", pattern, flag)
+			}
+		}
+
+		expr = pattern[size:end]
+		if len(flags) != 0 {
+			expr = "(?" + flags + ")" + expr
+		}
+	}
+
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid pattern `%s`: %s", pattern, err.Error())
+	}
+
+	patternCache.Store(pattern, re)
+	return re, nil
+}
+
+// valueRange returns the integers or the letters from start to end, both
+// included. The range counts down if end is less than start.
+func valueRange(start, end any) ([]any, error) {
+	startStr, startIsStr := start.(string)
+	endStr, endIsStr := end.(string)
+	if startIsStr && endIsStr && utf8.RuneCountInString(startStr) == 1 && utf8.RuneCountInString(endStr) == 1 {
+		from, _ := utf8.DecodeRuneInString(startStr)
+		to, _ := utf8.DecodeRuneInString(endStr)
+
+		step := rune(1)
+		if to < from {
+			step = -1
+		}
+
+		items := make([]any, 0, (to-from)*step+1)
+		for r := from; r != to+step; r += step {
+			items = append(items, string(r))
+		}
+		return items, nil
+	}
+
+	from, fromOk := toIndex(start)
+	to, toOk := toIndex(end)
+	if !fromOk || !toOk {
+		return nil, fmt.Errorf("range bounds should be integers or letters, got %T and %T", start, end)
+	}
+
+	step := 1
+	if to < from {
+		step = -1
+	}
+
+	items := make([]any, 0, (to-from)*step+1)
+	for i := from; i != to+step; i += step {
+		items = append(items, int64(i))
+	}
+	return items, nil
+}
+
+func (node Node) evaluateUnary(tmpl TemplateData) (any, error) {
+	if len(node.Children) != 1 {
+		return nil, fmt.Errorf("unary node should have exactly one child")
+	}
+
+	operand, err := node.Children[0].evaluateExpression(tmpl)
+	if err != nil {
+		return nil, err
+	}
+
+	switch node.Value {
+	case "not":
+		return !tmpl.isTruthy(operand), nil
+	case "-":
+		return arithmetic("-", int64(0), operand)
+	case "+":
+		return arithmetic("+", int64(0), operand)
+	default:
+		return nil, fmt.Errorf("invalid unary operator: %s", node.Value)
+	}
+}
+
+func (node Node) evaluateTernary(tmpl TemplateData) (any, error) {
+	if len(node.Children) != 2 && len(node.Children) != 3 {
+		return nil, fmt.Errorf("ternary node should have two or three children")
+	}
+
+	condition, err := node.Children[0].evaluateExpression(tmpl)
+	if err != nil {
+		return nil, err
+	}
+
+	if tmpl.isTruthy(condition) {
+		return node.Children[1].evaluateExpression(tmpl)
+	} else if len(node.Children) == 3 {
+		return node.Children[2].evaluateExpression(tmpl)
+	}
+	return "", nil
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	stdjson "encoding/json"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type arithmeticCase struct {
+	operator    string
+	left, right any
+	expected    any
+}
+
+func testArithmetic(t *testing.T, cases []arithmeticCase) {
+	t.Helper()
+
+	for _, c := range cases {
+		result, err := arithmetic(c.operator, c.left, c.right)
+		if err != nil {
+			t.Errorf("%v %s %v: unexpected error: %s", c.left, c.operator, c.right, err)
+		} else if !reflect.DeepEqual(result, c.expected) {
+			t.Errorf("%v %s %v: expected %#v, got %#v", c.left, c.operator, c.right, c.expected, result)
+		}
+	}
+}
+
+func TestArithmetic(t *testing.T) {
+	testArithmetic(t, []arithmeticCase{
+		{"+", int64(1), int64(2), int64(3)},
+		{"/", int64(1), int64(2), 0.5},
+		{"//", int64(7), int64(2), int64(3)},
+		{"//", int64(-7), int64(2), int64(-4)},
+		{"//", int64(7), int64(-2), int64(-4)},
+		{"//", int64(-8), int64(2), int64(-4)},
+		{"//", -7.5, int64(2), -4.0},
+		{"%", int64(-7), int64(2), int64(-1)},
+		{"%", -7.5, int64(2), -1.5},
+		{"**", int64(2), int64(10), 1024.0},
+		{"+", nil, true, int64(1)},
+		{"*", "3", 1.5, 4.5},
+		{"+", int64(math.MaxInt64), int64(1), float64(math.MaxInt64) + 1},
+		{"-", int64(math.MinInt64), int64(1), float64(math.MinInt64) - 1},
+		{"-", int64(0), int64(math.MinInt64), -float64(math.MinInt64)},
+		{"*", int64(math.MaxInt64), int64(2), float64(math.MaxInt64) * 2},
+		{"*", int64(math.MinInt64), int64(-1), -float64(math.MinInt64)},
+		{"//", int64(math.MinInt64), int64(-1), -float64(math.MinInt64)},
+		{"+", uint64(math.MaxUint64), int64(0), float64(math.MaxUint64)},
+		{"-", uint(1), uint8(2), int64(-1)},
+	})
+}
+
+func TestArithmeticErrors(t *testing.T) {
+	cases := []struct {
+		operator    string
+		left, right any
+		expected    string
+	}{
+		{"/", int64(1), int64(0), "division by zero"},
+		{"//", int64(1), int64(0), "division by zero"},
+		{"%", 1.5, 0.0, "division by zero"},
+		{"+", "a", int64(1), "unsupported operand types for +: string and int64"},
+	}
+
+	for _, c := range cases {
+		if _, err := arithmetic(c.operator, c.left, c.right); err == nil || err.Error() != c.expected {
+			t.Errorf("%v %s %v: expected error %q, got %v", c.left, c.operator, c.right, c.expected, err)
+		}
+	}
+}
+
+func TestCompareValues(t *testing.T) {
+	cases := []struct {
+		left, right any
+		expected    int
+	}{
+		{int64(1), 1.5, -1},
+		{uint64(math.MaxUint64), int64(math.MaxInt64), 1},
+		{"b", "a", 1},
+		{"a", "a", 0},
+	}
+
+	for _, c := range cases {
+		result, err := compareValues(c.left, c.right)
+		if err != nil {
+			t.Errorf("%#v <=> %#v: unexpected error: %s", c.left, c.right, err)
+		} else if result != c.expected {
+			t.Errorf("%#v <=> %#v: expected %d, got %d", c.left, c.right, c.expected, result)
+		}
+	}
+
+	if _, err := compareValues("a", int64(1)); err == nil {
+		t.Errorf("expected comparing a string with a number to fail")
+	}
+}
+
+func TestExactArithmetic(t *testing.T) {
+	testArithmetic(t, []arithmeticCase{
+		{"+", stdjson.Number("0.1"), stdjson.Number("0.2"), stdjson.Number("0.3")},
+		{"-", stdjson.Number("1"), 0.9, stdjson.Number("0.1")},
+		{"*", stdjson.Number("1.5"), int64(2), stdjson.Number("3")},
+		{"+", stdjson.Number("12345678901234567890"), int64(1), stdjson.Number("12345678901234567891")},
+		{"/", stdjson.Number("1"), stdjson.Number("8"), stdjson.Number("0.125")},
+		{"/", stdjson.Number("1"), stdjson.Number("3"), 1.0 / 3},
+		{"//", stdjson.Number("-7"), int64(2), stdjson.Number("-4")},
+		{"//", stdjson.Number("-7.5"), stdjson.Number("2"), stdjson.Number("-4")},
+		{"%", stdjson.Number("-7"), int64(2), stdjson.Number("-1")},
+		{"%", stdjson.Number("5.5"), stdjson.Number("2"), stdjson.Number("1.5")},
+	})
+
+	if _, err := arithmetic("/", stdjson.Number("1"), int64(0)); err == nil || err.Error() != "division by zero" {
+		t.Errorf("expected a division by zero, got %v", err)
+	}
+}
+
+func TestCompareExactNumbers(t *testing.T) {
+	cases := []struct {
+		left, right any
+		expected    int
+	}{
+		{stdjson.Number("0.3"), 0.3, 0},
+		{stdjson.Number("19.90"), stdjson.Number("19.9"), 0},
+		{stdjson.Number("12345678901234567891"), stdjson.Number("12345678901234567890"), 1},
+		{int64(1), stdjson.Number("1.5"), -1},
+	}
+
+	for _, c := range cases {
+		result, err := compareValues(c.left, c.right)
+		if err != nil {
+			t.Errorf("%#v <=> %#v: unexpected error: %s", c.left, c.right, err)
+		} else if result != c.expected {
+			t.Errorf("%#v <=> %#v: expected %d, got %d", c.left, c.right, c.expected, result)
+		}
+	}
+
+	if valuesEqual(stdjson.Number("1"), "1") {
+		t.Errorf("expected a number and a string to never be equal")
+	}
+}
+
+func TestOverflowingExactNumbers(t *testing.T) {
+	data, err := DecodeContextData([]byte(`{"big": 1e400, "negative": -1e400}`), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	big, negative := data["big"], data["negative"]
+
+	if number, ok := toNumber(big); !ok || !math.IsInf(number.(float64), 1) {
+		t.Errorf("expected 1e400 to be infinite, got %v", number)
+	}
+
+	if !TRUTHINESS_TWIG.IsTruthy(big) || !TRUTHINESS_DEFAULT.IsTruthy(negative) {
+		t.Errorf("expected 1e400 and -1e400 to be truthy")
+	}
+
+	if result, err := compareValues(negative, big); err != nil || result != -1 {
+		t.Errorf("expected -1e400 < 1e400, got %d, %v", result, err)
+	}
+
+	if !valuesEqual(big, big) || valuesEqual(big, 1.0) {
+		t.Errorf("expected 1e400 to only equal itself")
+	}
+
+	if result, err := arithmetic("+", big, int64(1)); err != nil || !reflect.DeepEqual(result, stdjson.Number("1"+strings.Repeat("0", 399)+"1")) {
+		t.Errorf("expected 1e400 + 1 to stay exact, got %v, %v", result, err)
+	}
+
+	goFn, err := NewGoFunc(func(f float64) float64 { return f })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result, err := goFn.Call(Arguments{Positional: []any{big}}); err != nil || !math.IsInf(result.(float64), 1) {
+		t.Errorf("expected 1e400 to convert to an infinite float64, got %v, %v", result, err)
+	}
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+)
+
+// typeInfo is the reflection metadata of a struct type needed for
+// resolving attributes. It is computed once per type.
+type typeInfo struct {
+	// fields maps both the `json` tag name and the Go name of an
+	// exported field to its index.
+	fields map[string][]int
+}
+
+var typeInfoCache sync.Map // map[reflect.Type]*typeInfo
+
+func getTypeInfo(structType reflect.Type) *typeInfo {
+	if cached, ok := typeInfoCache.Load(structType); ok {
+		return cached.(*typeInfo)
+	}
+
+	info := &typeInfo{fields: make(map[string][]int)}
+	tagged := make(map[string]bool)
+
+	for _, field := range reflect.VisibleFields(structType) {
+		if !field.IsExported() || field.Anonymous {
+			continue
+		}
+
+		tagName, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if tagName == "-" {
+			continue
+		} else if len(tagName) != 0 {
+			info.fields[tagName] = field.Index
+			tagged[tagName] = true
+		}
+
+		// tag names take precedence over the Go names
+		if !tagged[field.Name] {
+			info.fields[field.Name] = field.Index
+		}
+	}
+
+	cached, _ := typeInfoCache.LoadOrStore(structType, info)
+	return cached.(*typeInfo)
+}
+
+// capitalize turns an attribute such as `name` into the Go name `Name`.
+func capitalize(name string) string {
+	first, size := utf8.DecodeRuneInString(name)
+	if first == utf8.RuneError || unicode.IsUpper(first) {
+		return name
+	}
+	return string(unicode.ToUpper(first)) + name[size:]
+}
+
+// lookupField resolves the attribute of a struct. Besides the exact name,
+// the capitalized name is tried so that `user.name` finds `Name`.
+func lookupField(rv reflect.Value, name string) (any, bool) {
+	info := getTypeInfo(rv.Type())
+	fieldIndex, exists := info.fields[name]
+	if !exists {
+		fieldIndex, exists = info.fields[capitalize(name)]
+	}
+	if !exists {
+		return nil, false
+	}
+
+	field, err := rv.FieldByIndexErr(fieldIndex)
+	if err != nil {
+		// an embedded pointer on the way is nil
+		return nil, false
+	}
+	return field.Interface(), true
+}
+
+// findMethod returns the method named by the attribute, trying the
+// capitalized name as well.
+func findMethod(rv reflect.Value, name string) reflect.Value {
+	method := rv.MethodByName(name)
+	if !method.IsValid() {
+		method = rv.MethodByName(capitalize(name))
+	}
+	return method
+}
+
+// lookupMethod calls the zero-argument method named by the attribute. The
+// method may return a single value or a value and an error.
+func lookupMethod(rv reflect.Value, name string) (any, bool, error) {
+	method := findMethod(rv, name)
+	if !method.IsValid() {
+		return nil, false, nil
+	}
+
+	methodType := method.Type()
+	if methodType.NumIn() != 0 {
+		return nil, false, nil
+	}
+
+	switch methodType.NumOut() {
+	case 1:
+		return method.Call(nil)[0].Interface(), true, nil
+	case 2:
+		if !methodType.Out(1).Implements(errorType) {
+			return nil, false, nil
+		}
+
+		results := method.Call(nil)
+		if err, _ := results[1].Interface().(error); err != nil {
+			return nil, true, fmt.Errorf("%s: %s", name, err.Error())
+		}
+		return results[0].Interface(), true, nil
+	default:
+		return nil, false, nil
+	}
+}
+
+var errorType = reflect.TypeOf((*error)(nil)).Elem()
+
+// callMethod calls the method named by the attribute with the arguments of
+// the call, converting them like the arguments of a GoFunc.
+func callMethod(value any, name string, args Arguments) (any, bool, error) {
+	rv := reflect.ValueOf(value)
+	for rv.IsValid() {
+		if rv.Kind() != reflect.Interface {
+			if method := findMethod(rv, name); method.IsValid() {
+				goFn, err := NewGoFunc(method.Interface())
+				if err != nil {
+					return nil, true, err
+				}
+
+				result, err := goFn.Call(args)
+				if err != nil {
+					return nil, true, fmt.Errorf("%s: %s", name, err.Error())
+				}
+				return result, true, nil
+			}
+		}
+
+		if (rv.Kind() != reflect.Pointer && rv.Kind() != reflect.Interface) || rv.IsNil() {
+			break
+		}
+		rv = rv.Elem()
+	}
+	return nil, false, nil
+}
+
+// lookupReflect resolves an attribute or an index of any Go value: struct
+// fields (honouring `json` tags), maps with string-like or integer keys,
+// slices and arrays and, if enabled, zero-argument methods.
+func lookupReflect(value any, key any, callMethods bool) (any, bool, error) {
+	rv := reflect.ValueOf(value)
+	name := keyString(key)
+
+	for {
+		if callMethods && rv.Kind() != reflect.Interface && rv.IsValid() {
+			if result, found, err := lookupMethod(rv, name); found || err != nil {
+				return result, found, err
+			}
+		}
+
+		if rv.Kind() != reflect.Pointer && rv.Kind() != reflect.Interface {
+			break
+		} else if rv.IsNil() {
+			return nil, false, nil
+		}
+		rv = rv.Elem()
+	}
+
+	switch rv.Kind() {
+	case reflect.Struct:
+		gotValue, found := lookupField(rv, name)
+		return gotValue, found, nil
+	case reflect.Map:
+		mapKey, err := convertMapKey(key, rv.Type().Key())
+		if err != nil {
+			return nil, false, err
+		}
+
+		gotValue := rv.MapIndex(mapKey)
+		if !gotValue.IsValid() {
+			return nil, false, nil
+		}
+		return gotValue.Interface(), true, nil
+	case reflect.Slice, reflect.Array:
+		idx, ok := toIndex(key)
+		if !ok {
+			return nil, false, fmt.Errorf("array index should be an integer")
+		} else if idx < 0 || idx >= rv.Len() {
+			return nil, false, nil
+		}
+		return rv.Index(idx).Interface(), true, nil
+	case reflect.Bool, reflect.String, reflect.Float32, reflect.Float64,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		// scalars have no attributes, like strings
+		return nil, false, nil
+	default:
+		return nil, false, fmt.Errorf("cannot access `%s` of %T", name, value)
+	}
+}
+
+// sortMapKeys sorts the keys of a map so that iterating it is
+// deterministic. Numeric keys are sorted numerically and other keys by
+// their text.
+func sortMapKeys(keys []reflect.Value) {
+	if len(keys) == 0 {
+		return
+	}
+
+	switch keys[0].Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		sort.Slice(keys, func(i, j int) bool { return keys[i].Int() < keys[j].Int() })
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		sort.Slice(keys, func(i, j int) bool { return keys[i].Uint() < keys[j].Uint() })
+	case reflect.Float32, reflect.Float64:
+		sort.Slice(keys, func(i, j int) bool { return keys[i].Float() < keys[j].Float() })
+	default:
+		sort.Slice(keys, func(i, j int) bool {
+			return fmt.Sprint(keys[i].Interface()) < fmt.Sprint(keys[j].Interface())
+		})
+	}
+}
+
+func convertMapKey(key any, keyType reflect.Type) (reflect.Value, error) {
+	switch keyType.Kind() {
+	case reflect.String:
+		return reflect.ValueOf(keyString(key)).Convert(keyType), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		idx, ok := toIndex(key)
+		if !ok {
+			return reflect.Value{}, fmt.Errorf("map key should be an integer")
+		}
+		return reflect.ValueOf(idx).Convert(keyType), nil
+	default:
+		return reflect.Value{}, fmt.Errorf("unsupported map key type %s", keyType)
+	}
+}
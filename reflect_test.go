@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+type testAddress struct {
+	City string `json:"city"`
+}
+
+type testProfile struct {
+	*testAddress
+	Name     string `json:"display_name"`
+	Password string `json:"-"`
+	Tags     []string
+	Scores   map[int]string
+	age      int
+}
+
+func (p testProfile) Greeting() string { return "hello " + p.Name }
+
+func (p *testProfile) Initials() (string, error) {
+	if len(p.Name) == 0 {
+		return "", fmt.Errorf("no name")
+	}
+	return p.Name[:1], nil
+}
+
+func TestLookupReflect(t *testing.T) {
+	profile := &testProfile{
+		testAddress: &testAddress{City: "Manila"},
+		Name:        "Ned",
+		Password:    "secret",
+		Tags:        []string{"a", "b"},
+		Scores:      map[int]string{2: "two"},
+		age:         30,
+	}
+
+	cases := []struct {
+		key         any
+		callMethods bool
+		expected    any
+		found       bool
+	}{
+		{"display_name", false, "Ned", true},
+		{"Name", false, "Ned", true},
+		{"name", false, "Ned", true},
+		{"city", false, "Manila", true},
+		{"Password", false, nil, false},
+		{"password", false, nil, false},
+		{"age", false, nil, false},
+		{"Tags", false, []string{"a", "b"}, true},
+		{"greeting", false, nil, false},
+		{"greeting", true, "hello Ned", true},
+		{"initials", true, "N", true},
+	}
+
+	for _, c := range cases {
+		value, found, err := lookupKey(profile, c.key, c.callMethods)
+		if err != nil {
+			t.Errorf("%v: unexpected error: %s", c.key, err)
+		} else if found != c.found || !reflect.DeepEqual(value, c.expected) {
+			t.Errorf("%v: expected %#v (%t), got %#v (%t)", c.key, c.expected, c.found, value, found)
+		}
+	}
+
+	if value, found, err := lookupKey(profile.Scores, int64(2), false); err != nil || !found || value != "two" {
+		t.Errorf("expected the integer key to be found, got %#v, %v", value, err)
+	}
+
+	if value, found, err := lookupKey(profile.Tags, 1, false); err != nil || !found || value != "b" {
+		t.Errorf("expected the slice index to be found, got %#v, %v", value, err)
+	}
+
+	if _, _, err := lookupKey(&testProfile{}, "initials", true); err == nil || err.Error() != "initials: no name" {
+		t.Errorf("expected the error of the method, got %v", err)
+	}
+
+	var nilProfile *testProfile
+	if _, found, err := lookupKey(nilProfile, "Name", false); found || err != nil {
+		t.Errorf("expected a nil pointer to have no attributes, got %t, %v", found, err)
+	}
+}
+
+func TestIterateMaps(t *testing.T) {
+	cases := []struct {
+		value any
+		keys  []any
+	}{
+		{map[int]string{10: "c", 2: "b", 1: "a"}, []any{1, 2, 10}},
+		{map[int64]bool{-5: true, 3: true, -20: true}, []any{int64(-20), int64(-5), int64(3)}},
+		{map[uint8]bool{200: true, 9: true}, []any{uint8(9), uint8(200)}},
+		{map[float64]bool{1.5: true, 10: true, -2: true}, []any{-2.0, 1.5, 10.0}},
+		{map[string]int{"b": 1, "a": 2, "10": 3}, []any{"10", "a", "b"}},
+	}
+
+	for _, c := range cases {
+		keys, _, err := iterate(c.value)
+		if err != nil {
+			t.Errorf("%v: unexpected error: %s", c.value, err)
+		} else if !reflect.DeepEqual(keys, c.keys) {
+			t.Errorf("%v: expected the keys %v, got %v", c.value, c.keys, keys)
+		}
+	}
+}
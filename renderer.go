@@ -1,6 +1,7 @@
 package main
 
 import (
+	"bytes"
 	"fmt"
 	"io"
 )
@@ -18,11 +19,54 @@ func renderChildren(children []Node, tmpl TemplateData, renderer Renderer) error
 	return nil
 }
 
+// renderBlock renders the definition of the block at the given level of
+// its inheritance chain (0 being the most derived one). The fallback is
+// the default content of the yield node, rendered once the chain runs out.
+func renderBlock(name string, level int, fallback []Node, tmpl TemplateData, renderer Renderer) error {
+	tmpl.Context.block = &blockFrame{
+		name:     name,
+		level:    level,
+		fallback: fallback,
+	}
+
+	if definitions := tmpl.Context.Blocks[name]; level < len(definitions) {
+		return renderChildren(definitions[level], tmpl, renderer)
+	}
+	return renderChildren(fallback, tmpl, renderer)
+}
+
+// evaluateParentBlock renders the content the current block overrides.
+func evaluateParentBlock(tmpl TemplateData) (any, error) {
+	frame := tmpl.Context.block
+	if frame == nil {
+		return nil, fmt.Errorf("parent can only be used inside a block")
+	} else if frame.level >= len(tmpl.Context.Blocks[frame.name]) {
+		return nil, fmt.Errorf("`%s` block has no parent content", frame.name)
+	}
+
+	writer := &bytes.Buffer{}
+	if err := renderBlock(frame.name, frame.level+1, frame.fallback, tmpl, &simpleRenderer{writer: writer, formatter: tmpl.Formatter}); err != nil {
+		return nil, err
+	}
+	return writer.String(), nil
+}
+
+// renderToString renders the nodes into a string instead of the current
+// renderer. Used for capturing output into variables.
+func renderToString(children []Node, tmpl TemplateData) (string, error) {
+	writer := &bytes.Buffer{}
+	if err := renderChildren(children, tmpl, &simpleRenderer{writer: writer, formatter: tmpl.Formatter}); err != nil {
+		return "", err
+	}
+	return writer.String(), nil
+}
+
 type simpleRenderer struct {
-	writer io.Writer
+	writer    io.Writer
+	formatter Formatter
 }
 
 func (wr *simpleRenderer) Write(value any) error {
-	_, err := wr.writer.Write([]byte(fmt.Sprintf("%s", value)))
+	_, err := io.WriteString(wr.writer, wr.formatter.Format(value))
 	return err
 }
@@ -2,54 +2,258 @@ package main
 
 import (
 	"fmt"
+	"path/filepath"
+	"strings"
 
 	jsoniter "github.com/json-iterator/go"
 )
 
 var json = jsoniter.ConfigCompatibleWithStandardLibrary
 
-type FilterFunc func(value any) (any, error)
+// exactJson decodes numbers as json.Number instead of float64 so that
+// large integers and decimals keep their precision.
+var exactJson = jsoniter.Config{
+	EscapeHTML:             true,
+	SortMapKeys:            true,
+	ValidateJsonRawMessage: true,
+	UseNumber:              true,
+}.Froze()
 
-func (filterFn FilterFunc) ToFunction() FunctionFunc {
-	return func(arguments any) (any, error) {
-		if args, ok := arguments.([]any); ok {
-			return filterFn(args[0])
-		} else if args, ok := arguments.(map[string]any); ok {
-			for _, v := range args {
-				return filterFn(v)
+type Template struct {
+	Name       string
+	Version    string
+	Truthiness TruthinessProfile `json:"truthiness"`
+	blocks     map[string][]Node `json:"-"`
+	extends    string            `json:"-"`
+	RootNode   Node              `json:"root_node"`
+
+	macros map[string]*Macro
+
+	// uses are the names of the templates whose blocks are imported by
+	// `use` nodes.
+	uses []string
+
+	// references are the names of the templates this template includes,
+	// extends or uses, as far as they are known without rendering.
+	references []string
+}
+
+func (tmpl *Template) scan() error {
+	tmpl.extends = ""
+	tmpl.uses = nil
+	tmpl.references = nil
+	tmpl.macros = make(map[string]*Macro)
+	for k := range tmpl.blocks {
+		delete(tmpl.blocks, k)
+	}
+	return tmpl.RootNode.scan(nil, false, tmpl)
+}
+
+type ContextData struct {
+	// Blocks maps a block name to its definitions, from the most derived
+	// template to the least derived one.
+	Blocks map[string][][]Node
+	// Data is the value variables are looked up in: a map or any Go value
+	// whose fields, keys or methods are resolved through reflection.
+	Data any `json:"data"`
+	// Globals are looked up when a variable is not in Data.
+	Globals Globals
+	scope   *scope
+	block   *blockFrame
+	// resolved and resolvedGlobals cache the lazy values of Data and
+	// Globals that have been read.
+	resolved        map[string]any
+	resolvedGlobals map[string]any
+}
+
+// blockFrame tracks the block being rendered so that the parent node
+// knows which definition comes next.
+type blockFrame struct {
+	name     string
+	level    int
+	fallback []Node
+}
+
+// withDefinitions returns a copy of the blocks with the given definitions
+// added as the least derived ones.
+func withDefinitions(blocks map[string][][]Node, definitions map[string][]Node) map[string][][]Node {
+	if len(definitions) == 0 && blocks != nil {
+		return blocks
+	}
+
+	newBlocks := make(map[string][][]Node, len(blocks)+len(definitions))
+	for name, chain := range blocks {
+		newBlocks[name] = chain
+	}
+	for name, body := range definitions {
+		chain := make([][]Node, 0, len(newBlocks[name])+1)
+		newBlocks[name] = append(append(chain, newBlocks[name]...), body)
+	}
+	return newBlocks
+}
+
+// scope holds the variables introduced while rendering (loop targets,
+// assignments). Lookups walk the scopes from the innermost one before
+// falling back to the context data, so leaving a scope restores whatever
+// it shadowed.
+type scope struct {
+	vars   map[string]any
+	parent *scope
+	// template marks the outermost scope of a rendered template, which
+	// nearest assignments do not go past
+	template bool
+}
+
+// withOverrides returns a copy of the blocks with the given definitions
+// added as the most derived ones.
+func withOverrides(blocks map[string][][]Node, definitions map[string][]Node) map[string][][]Node {
+	newBlocks := make(map[string][][]Node, len(blocks)+len(definitions))
+	for name, chain := range blocks {
+		newBlocks[name] = chain
+	}
+	for name, body := range definitions {
+		chain := make([][]Node, 0, len(newBlocks[name])+1)
+		newBlocks[name] = append(append(chain, body), newBlocks[name]...)
+	}
+	return newBlocks
+}
+
+func (ctx ContextData) newScope() ContextData {
+	if ctx.resolved == nil {
+		ctx.resolved = make(map[string]any)
+	}
+	if ctx.resolvedGlobals == nil {
+		ctx.resolvedGlobals = make(map[string]any)
+	}
+	ctx.scope = &scope{
+		vars:   make(map[string]any),
+		parent: ctx.scope,
+	}
+	return ctx
+}
+
+func (ctx ContextData) Get(name string) (any, bool) {
+	value, exists, _ := ctx.lookup(name, false)
+	return value, exists
+}
+
+// lookup resolves the variable from the scopes, then from the context
+// data and the globals. Lazy values are computed on the first read and replaced by their
+// result.
+func (ctx ContextData) lookup(name string, callMethods bool) (any, bool, error) {
+	for sc := ctx.scope; sc != nil; sc = sc.parent {
+		if value, exists := sc.vars[name]; exists {
+			resolved, isLazy, err := resolveLazy(value)
+			if err != nil {
+				return nil, true, err
+			} else if isLazy {
+				sc.vars[name] = resolved
 			}
-		} else {
-			return filterFn(arguments)
+			return resolved, true, nil
 		}
-		return "", nil
 	}
+
+	if value, exists := ctx.resolved[name]; exists {
+		return value, true, nil
+	}
+
+	cache := ctx.resolved
+	value, exists, err := lookupKey(ctx.Data, name, callMethods)
+	if err != nil {
+		return nil, false, err
+	} else if !exists {
+		if value, exists = ctx.resolvedGlobals[name]; exists {
+			return value, true, nil
+		} else if value, exists = ctx.Globals[name]; !exists {
+			return nil, false, nil
+		}
+		cache = ctx.resolvedGlobals
+	}
+
+	resolved, isLazy, err := resolveLazy(value)
+	if err != nil {
+		return nil, true, err
+	} else if isLazy && cache != nil {
+		cache[name] = resolved
+	}
+	return resolved, true, nil
 }
 
-type FunctionFunc func(arguments any) (any, error)
+// isolate returns a context that only sees the given data and the globals.
+func (ctx ContextData) isolate(blocks map[string][][]Node, data any) ContextData {
+	return ContextData{
+		Blocks:          blocks,
+		Data:            data,
+		Globals:         ctx.Globals,
+		resolvedGlobals: ctx.resolvedGlobals,
+	}
+}
 
-type Template struct {
-	Name     string
-	Version  string
-	blocks   map[string][]Node `json:"-"`
-	RootNode Node              `json:"root_node"`
+func (ctx ContextData) Set(name string, value any) {
+	ctx.scope.vars[name] = value
 }
 
-func (tmpl *Template) scanBlocks() {
-	for _, cn := range tmpl.RootNode.Children {
-		_ = cn.scanBlock("", tmpl.blocks)
+// SetGlobal assigns the variable to the outermost scope of the render and
+// to every enclosing scope that already defines it, making the value
+// visible after the current scope is left.
+func (ctx ContextData) SetGlobal(name string, value any) {
+	sc := ctx.scope
+	for ; sc.parent != nil; sc = sc.parent {
+		if _, exists := sc.vars[name]; exists {
+			sc.vars[name] = value
+		}
 	}
+	sc.vars[name] = value
 }
 
-type ContextData struct {
-	Blocks map[string][]Node
-	Data   map[string]any `json:"data"`
+// SetNearest assigns the variable to the innermost scope of the current
+// template that already defines it, or to the current scope if none does.
+func (ctx ContextData) SetNearest(name string, value any) {
+	for sc := ctx.scope; sc != nil; sc = sc.parent {
+		if _, exists := sc.vars[name]; exists {
+			sc.vars[name] = value
+			return
+		} else if sc.template {
+			break
+		}
+	}
+	ctx.Set(name, value)
 }
 
+// DEFAULT_MAX_INCLUDE_DEPTH is the depth limit used when none is
+// configured.
+const DEFAULT_MAX_INCLUDE_DEPTH = 64
+
 type TemplateData struct {
 	Context   ContextData
-	Filters   map[string]FilterFunc
-	Functions map[string]FunctionFunc // funky
+	Filters   map[string]Filter
+	Functions map[string]Function // funky
 	Templates TemplateStore
+
+	// Formatter converts the values into text when they are written or
+	// concatenated.
+	Formatter Formatter
+
+	// Undefined decides how missing variables are handled. An empty
+	// policy is strict.
+	Undefined UndefinedPolicy
+
+	// CallMethods allows attributes to call the methods of Go values in the
+	// context data.
+	CallMethods bool
+
+	// MaxIncludeDepth limits how many templates can be nested through
+	// includes and extends, and how deep macro calls can go. Zero means
+	// DEFAULT_MAX_INCLUDE_DEPTH.
+	MaxIncludeDepth int
+
+	// stack holds the names of the templates being rendered, outermost
+	// first.
+	stack []string
+
+	// current is the template whose nodes are being evaluated.
+	current   *Template
+	callDepth int
 }
 
 type TemplateStore map[string]*Template
@@ -64,20 +268,145 @@ func (tmps TemplateStore) Render(name string, data TemplateData, renderer Render
 		return fmt.Errorf("template `%s` does not exist", name)
 	}
 
-	if data.Context.Blocks == nil {
-		withBlocksInData := TemplateData{
-			Context: ContextData{
-				Blocks: selectedTemplate.blocks,
-				Data:   data.Context.Data,
-			},
-			Filters:   data.Filters,
-			Functions: data.Functions,
-			Templates: data.Templates,
+	return tmps.renderTemplate(selectedTemplate, data, renderer)
+}
+
+// maxIncludeDepth returns the configured depth limit or the default one.
+func (tmpl TemplateData) maxIncludeDepth() int {
+	if tmpl.MaxIncludeDepth <= 0 {
+		return DEFAULT_MAX_INCLUDE_DEPTH
+	}
+	return tmpl.MaxIncludeDepth
+}
+
+// renderTemplate renders the template or, if it extends another one, its
+// parent layout with the template's blocks overriding the parent's.
+func (tmps TemplateStore) renderTemplate(selectedTemplate *Template, data TemplateData, renderer Renderer) error {
+	// a template may include itself under a condition, such as a tree
+	// partial rendering its children, so only the depth limit ends runaway
+	// recursions. The error names the part of the chain that repeats.
+	if maxDepth := data.maxIncludeDepth(); len(data.stack) >= maxDepth {
+		chain := append(append([]string{}, data.stack...), selectedTemplate.Name)
+		if cycle := repeatingSegment(chain); cycle != nil {
+			return fmt.Errorf("include cycle detected: %s (maximum include depth of %d exceeded)", strings.Join(cycle, " -> "), maxDepth)
 		}
-		return selectedTemplate.RootNode.evaluate(withBlocksInData, renderer)
-	} else {
+		return fmt.Errorf("maximum include depth of %d exceeded: %s", maxDepth, strings.Join(chain, " -> "))
+	}
+
+	// the capacity is capped so that sibling includes do not share the
+	// backing array.
+	data.stack = append(data.stack[:len(data.stack):len(data.stack)], selectedTemplate.Name)
+	data.current = selectedTemplate
+
+	// blocks passed by the caller take precedence over the template's own,
+	// which take precedence over the ones it uses
+	data.Context.Blocks = withDefinitions(data.Context.Blocks, selectedTemplate.blocks)
+	usedBlocks, err := tmps.withUsedBlocks(data.Context.Blocks, selectedTemplate)
+	if err != nil {
+		return err
+	}
+	data.Context.Blocks = usedBlocks
+
+	// every template gets its own scope so that variables it assigns
+	// do not leak into the template that included it.
+	data.Context = data.Context.newScope()
+	data.Context.scope.template = true
+
+	if len(selectedTemplate.extends) == 0 {
 		return selectedTemplate.RootNode.evaluate(data, renderer)
 	}
+
+	parentTemplate, templateExists := tmps[selectedTemplate.extends]
+	if !templateExists {
+		return fmt.Errorf("template `%s` extends `%s` which does not exist", selectedTemplate.Name, selectedTemplate.extends)
+	}
+
+	// the top-level assignments are run before the layout renders, so the
+	// layout and the blocks can read them
+	for _, cn := range selectedTemplate.RootNode.Children {
+		if cn.isAssignment() {
+			if err := cn.evaluate(data, renderer); err != nil {
+				return err
+			}
+		}
+	}
+	return tmps.renderTemplate(parentTemplate, data, renderer)
+}
+
+// withUsedBlocks adds the blocks of the templates used by the template, and
+// of the ones they use in turn, as the least derived definitions.
+func (tmps TemplateStore) withUsedBlocks(blocks map[string][][]Node, template *Template) (map[string][][]Node, error) {
+	for _, name := range template.uses {
+		usedTemplate, templateExists := tmps[name]
+		if !templateExists {
+			return nil, fmt.Errorf("template `%s` uses `%s` which does not exist", template.Name, name)
+		}
+
+		var err error
+		blocks, err = tmps.withUsedBlocks(withDefinitions(blocks, usedTemplate.blocks), usedTemplate)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return blocks, nil
+}
+
+// templateNameFromPath returns the name a template file is loaded as, which
+// is its file name without the extension.
+func templateNameFromPath(path string) string {
+	fileName := filepath.Base(path)
+	return strings.TrimSuffix(fileName, filepath.Ext(fileName))
+}
+
+// repeatingSegment returns the shortest end of the chain that starts and
+// ends with its last template, such as `page -> a -> b -> page`.
+func repeatingSegment(chain []string) []string {
+	last := len(chain) - 1
+	for i := last - 1; i >= 0; i-- {
+		if chain[i] == chain[last] {
+			return chain[i:]
+		}
+	}
+	return nil
+}
+
+// findCycle looks for a chain of unconditional includes and extends that
+// starts from the template and leads back to a template already in the
+// chain.
+func (tmps TemplateStore) findCycle(template *Template) []string {
+	visited := map[string]bool{}
+
+	var visit func(current *Template, chain []string) []string
+	visit = func(current *Template, chain []string) []string {
+		for i, name := range chain {
+			if name == current.Name {
+				return append(append([]string{}, chain[i:]...), current.Name)
+			}
+		}
+
+		if visited[current.Name] {
+			return nil
+		}
+
+		chain = append(chain, current.Name)
+		for _, ref := range current.references {
+			next, templateExists := tmps[ref]
+			if ref == template.Name {
+				next, templateExists = template, true
+			}
+
+			if !templateExists {
+				continue
+			} else if cycle := visit(next, chain); cycle != nil {
+				return cycle
+			}
+		}
+
+		visited[current.Name] = true
+		return nil
+	}
+
+	return visit(template, nil)
 }
 
 func newTemplate() *Template {
@@ -87,7 +416,18 @@ func newTemplate() *Template {
 }
 
 func (tmps TemplateStore) Add(template *Template) error {
-	template.scanBlocks()
+	if template.blocks == nil {
+		template.blocks = make(map[string][]Node)
+	}
+
+	if err := template.Truthiness.Validate(); err != nil {
+		return fmt.Errorf("template `%s`: %s", template.Name, err.Error())
+	} else if err := template.scan(); err != nil {
+		return fmt.Errorf("template `%s`: %s", template.Name, err.Error())
+	} else if cycle := tmps.findCycle(template); cycle != nil {
+		return fmt.Errorf("template `%s`: include cycle detected: %s", template.Name, strings.Join(cycle, " -> "))
+	}
+
 	tmps[template.Name] = template
 	return nil
 }
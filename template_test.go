@@ -0,0 +1,178 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+var twigCard = twigFile{"card.twig", "[card {{ title ?? 'untitled' }}: {% block body %}body{% endblock %}]"}
+
+func TestRenderTwigComposition(t *testing.T) {
+	testRender(t, []renderCase{
+		{
+			name: "include names",
+			files: []twigFile{
+				twigCard,
+				{"page.twig", "{% include ['missing.twig', 'partials/card.twig'] %}|{% include 'ca' ~ 'rd' %}"},
+			},
+			expected: "[card untitled: body]|[card untitled: body]",
+		},
+		{
+			name: "blocks of the includer",
+			files: []twigFile{
+				twigCard,
+				{"base.twig", "<{% block body %}BASE{% endblock %}|{% block main %}{% endblock %}>"},
+				{"page.twig", "{% extends 'base.twig' %}{% block body %}PAGE{% endblock %}{% block main %}{% include 'card.twig' %}{% embed 'card.twig' %}{% block title %}x{% endblock %}{% endembed %}{% endblock %}"},
+			},
+			expected: "<PAGE|[card untitled: body][card untitled: body]>",
+		},
+		{
+			name: "top-level set of a child",
+			files: []twigFile{
+				{"base.twig", "[{{ active|default('none') }}|{% block body %}{% endblock %}]"},
+				{"page.twig", "{% extends 'base.twig' %}{% set active = 'home' %}{% set title %}Home{% endset %}{% block body %}{{ title }}{% endblock %}ignored"},
+			},
+			expected: "[home|Home]",
+		},
+		{
+			name: "use",
+			files: []twigFile{
+				{"shared.twig", "{% block footer %}shared footer{% endblock %}"},
+				{"base.twig", "<{% block footer %}{% endblock %}>"},
+				{"page.twig", "{% extends 'base.twig' %}{% use 'shared.twig' %}"},
+			},
+			expected: "<shared footer>",
+		},
+	})
+}
+
+func TestRenderTwigIncludeCycles(t *testing.T) {
+	testRender(t, []renderCase{
+		{
+			name: "conditional recursion",
+			files: []twigFile{
+				{"page.twig", "[{{ n }}{% if n > 0 %}{% include 'page' with {n: n - 1} %}{% endif %}]"},
+			},
+			data:     map[string]any{"n": 3},
+			expected: "[3[2[1[0]]]]",
+		},
+	})
+
+	for _, maxDepth := range []int{0, 16} {
+		testApp, err := newTwigApp(
+			twigFile{"a.twig", "{% include 'b' %}"},
+			twigFile{"b.twig", "{% include next %}"},
+			twigFile{"page.twig", "<{% include 'a' with {next: 'page'} %}>"},
+			twigFile{"tree.twig", "[{% if true %}{% include 'tree' %}{% endif %}]"},
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+		testApp.MaxIncludeDepth = maxDepth
+
+		expected := "include cycle detected: a -> b -> page -> a (maximum include depth of 16 exceeded)"
+		if maxDepth == 0 {
+			expected = strings.Replace(expected, "16", "64", 1)
+		}
+		if _, err := testApp.Render("page", nil); err == nil || err.Error() != expected {
+			t.Errorf("expected %q, got %v", expected, err)
+		}
+
+		if _, err := testApp.Render("tree", nil); err == nil || !strings.HasPrefix(err.Error(), "include cycle detected: tree -> tree (") {
+			t.Errorf("expected the include depth to be exceeded, got %v", err)
+		}
+	}
+
+	if _, err := newTwigApp(twigFile{"tree.twig", "[{% include 'tree' %}]"}); err == nil || !strings.Contains(err.Error(), "include cycle detected: tree -> tree") {
+		t.Errorf("expected an include cycle, got %v", err)
+	}
+}
+
+func TestRenderExtends(t *testing.T) {
+	base := twigFile{"base.twig", "<{% block title %}Site{% endblock %}|{% block content %}default{% endblock %}>"}
+
+	testRender(t, []renderCase{
+		{
+			name:     "parent",
+			files:    []twigFile{base, {"page.twig", "{% extends 'base.twig' %}{% block title %}{{ parent() }} - Page{% endblock %}"}},
+			expected: "<Site - Page|default>",
+		},
+		{
+			name: "multiple levels",
+			files: []twigFile{
+				base,
+				{"section.twig", "{% extends 'base' %}{% block title %}{{ parent() }} - Section{% endblock %}{% block content %}[{{ parent() }}]{% endblock %}"},
+				{"page.twig", "{% extends 'section' %}{% block title %}{{ parent() }} - Page{% endblock %}"},
+			},
+			expected: "<Site - Section - Page|[default]>",
+		},
+	})
+}
+
+func TestRenderInclude(t *testing.T) {
+	testRender(t, []renderCase{
+		{
+			name: "variables",
+			files: []twigFile{
+				twigCard,
+				{"page.twig", "{% include 'card.twig' %}|{% include 'card.twig' with {title: 'A'} %}|{% include 'card.twig' with {} only %}|{{ title }}"},
+			},
+			data:     map[string]any{"title": "T"},
+			expected: "[card T: body]|[card A: body]|[card untitled: body]|T",
+		},
+		{
+			name: "ignore missing",
+			files: []twigFile{
+				twigCard,
+				{"page.twig", "<{% include 'missing.twig' ignore missing %}>"},
+			},
+			expected: "<>",
+		},
+		{
+			name: "dynamic names",
+			files: []twigFile{
+				twigCard,
+				{"page.twig", "{% include name %}|{% include ['missing', name] %}"},
+			},
+			data:     map[string]any{"name": "card"},
+			expected: "[card untitled: body]|[card untitled: body]",
+		},
+	})
+
+	testApp, err := newTwigApp(twigCard, twigFile{"page.twig", "{% include 'missing.twig' %}"})
+	if err != nil {
+		t.Fatal(err)
+	} else if _, err := testApp.Render("page", nil); err == nil || err.Error() != "template `missing` does not exist" {
+		t.Errorf("expected a missing template error, got %v", err)
+	}
+}
+
+func TestRenderEmbed(t *testing.T) {
+	testRender(t, []renderCase{
+		{
+			name: "overrides",
+			files: []twigFile{
+				twigCard,
+				{"page.twig", "{% embed 'card.twig' with {title: 'E'} %}{% block body %}EMBED {{ title }}, {{ parent() }}{% endblock %}{% endembed %}|{% include 'card.twig' %}"},
+			},
+			expected: "[card E: EMBED E, body]|[card untitled: body]",
+		},
+		{
+			name: "siblings",
+			files: []twigFile{
+				twigCard,
+				{"page.twig", "{% embed 'card.twig' %}{% block body %}one{% endblock %}{% endembed %}{% embed 'card.twig' %}{% block body %}two{% endblock %}{% endembed %}"},
+			},
+			expected: "[card untitled: one][card untitled: two]",
+		},
+		{
+			name: "only",
+			files: []twigFile{
+				twigCard,
+				{"page.twig", "{% embed 'card.twig' only %}{% block body %}{{ title ?? 'hidden' }}{% endblock %}{% endembed %}"},
+			},
+			data:     map[string]any{"title": "T"},
+			expected: "[card untitled: hidden]",
+		},
+	})
+}
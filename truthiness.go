@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+)
+
+// TruthinessProfile decides which values are falsy in conditions and
+// loops. Templates declare it in their header so that they behave like the
+// language they were written in.
+type TruthinessProfile string
+
+const (
+	// TRUTHINESS_DEFAULT is used by templates without a profile and
+	// follows the Go template rules.
+	TRUTHINESS_DEFAULT     TruthinessProfile = ""
+	TRUTHINESS_TWIG        TruthinessProfile = "twig"
+	TRUTHINESS_JINJA       TruthinessProfile = "jinja"
+	TRUTHINESS_MUSTACHE    TruthinessProfile = "mustache"
+	TRUTHINESS_LIQUID      TruthinessProfile = "liquid"
+	TRUTHINESS_GO_TEMPLATE TruthinessProfile = "go-template"
+)
+
+func (profile TruthinessProfile) Validate() error {
+	switch profile {
+	case TRUTHINESS_DEFAULT, TRUTHINESS_TWIG, TRUTHINESS_JINJA, TRUTHINESS_MUSTACHE, TRUTHINESS_LIQUID, TRUTHINESS_GO_TEMPLATE:
+		return nil
+	default:
+		return fmt.Errorf("unknown truthiness profile: %s", profile)
+	}
+}
+
+// IsTruthy reports whether the value counts as true under the profile.
+//
+//	twig:        null, false, 0, "", "0" and empty arrays or objects are falsy
+//	jinja:       null, false, 0, "" and empty arrays or objects are falsy
+//	mustache:    null, false, 0, NaN, "" and empty arrays are falsy
+//	liquid:      only null and false are falsy
+//	go-template: false, 0, nil and empty strings, arrays or objects are falsy
+func (profile TruthinessProfile) IsTruthy(value any) bool {
+	if _, isUndefined := value.(Undefined); value == nil || isUndefined {
+		return false
+	} else if boolVal, ok := value.(bool); ok {
+		return boolVal
+	} else if profile == TRUTHINESS_LIQUID {
+		return true
+	}
+
+	if number, ok := asNumber(value); ok {
+		if floatVal, ok := number.(float64); ok && math.IsNaN(floatVal) {
+			return profile != TRUTHINESS_MUSTACHE
+		}
+		return toFloat(number) != 0
+	}
+
+	if strVal, ok := value.(string); ok {
+		return len(strVal) != 0 && !(profile == TRUTHINESS_TWIG && strVal == "0")
+	}
+
+	rv := reflect.ValueOf(value)
+	switch rv.Kind() {
+	case reflect.Slice, reflect.Array:
+		return rv.Len() != 0
+	case reflect.Map:
+		return profile == TRUTHINESS_MUSTACHE || rv.Len() != 0
+	case reflect.Pointer, reflect.Interface:
+		return !rv.IsNil()
+	default:
+		return true
+	}
+}
+
+// iteratesOnce reports whether a loop over the value should render its body
+// once with the value itself, like a Mustache section over a non-list.
+func (profile TruthinessProfile) iteratesOnce(value any) bool {
+	if profile != TRUTHINESS_MUSTACHE {
+		return false
+	}
+
+	kind := reflect.ValueOf(value).Kind()
+	return kind != reflect.Slice && kind != reflect.Array
+}
+
+func (tmpl TemplateData) truthiness() TruthinessProfile {
+	if tmpl.current == nil {
+		return TRUTHINESS_DEFAULT
+	}
+	return tmpl.current.Truthiness
+}
+
+func (tmpl TemplateData) isTruthy(value any) bool {
+	return tmpl.truthiness().IsTruthy(value)
+}
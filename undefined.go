@@ -0,0 +1,175 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+)
+
+// UndefinedPolicy decides what happens when a template reads a variable
+// or an attribute that does not exist.
+type UndefinedPolicy string
+
+const (
+	// UNDEFINED_STRICT fails the render.
+	UNDEFINED_STRICT UndefinedPolicy = "strict"
+	// UNDEFINED_LENIENT renders nothing, but accessing an attribute of an
+	// undefined value still fails.
+	UNDEFINED_LENIENT UndefinedPolicy = "lenient"
+	// UNDEFINED_CHAINABLE renders nothing and accessing an attribute of an
+	// undefined value is undefined as well.
+	UNDEFINED_CHAINABLE UndefinedPolicy = "chainable"
+	// UNDEFINED_DEBUG works like UNDEFINED_LENIENT but renders a visible
+	// marker such as `{{ user.name }}`.
+	UNDEFINED_DEBUG UndefinedPolicy = "debug"
+)
+
+func (policy UndefinedPolicy) String() string {
+	return string(policy)
+}
+
+func (policy *UndefinedPolicy) Set(value string) error {
+	switch UndefinedPolicy(value) {
+	case UNDEFINED_STRICT, UNDEFINED_LENIENT, UNDEFINED_CHAINABLE, UNDEFINED_DEBUG:
+		*policy = UndefinedPolicy(value)
+		return nil
+	default:
+		return fmt.Errorf("unknown undefined policy: %s", value)
+	}
+}
+
+func (*UndefinedPolicy) Type() string {
+	return "undefined_policy"
+}
+
+// Undefined is the value of a missing variable or attribute when the
+// undefined policy is not strict. It behaves like null in operations.
+type Undefined struct {
+	Path string
+	Name string
+}
+
+// undefined returns the result of a failed lookup according to the
+// undefined policy of the render.
+func (tmpl TemplateData) undefined(err *UndefinedError) (any, error) {
+	if len(tmpl.Undefined) == 0 || tmpl.Undefined == UNDEFINED_STRICT {
+		return nil, err
+	}
+	return Undefined{Path: err.Path, Name: err.Name}, nil
+}
+
+// isUndefined reports whether the result of an evaluation is undefined,
+// either as an error (strict policy) or as a value.
+func isUndefined(value any, err error) bool {
+	var undefinedErr *UndefinedError
+	if errors.As(err, &undefinedErr) {
+		return true
+	}
+
+	_, ok := value.(Undefined)
+	return err == nil && ok
+}
+
+// isEmpty follows Twig's `empty` test: undefined, null, false, empty
+// strings and empty arrays or objects are empty.
+func isEmpty(value any) bool {
+	switch v := value.(type) {
+	case nil, Undefined:
+		return true
+	case bool:
+		return !v
+	case string:
+		return len(v) == 0
+	}
+
+	rv := reflect.ValueOf(value)
+	switch rv.Kind() {
+	case reflect.Slice, reflect.Array, reflect.Map:
+		return rv.Len() == 0
+	default:
+		return false
+	}
+}
+
+// evaluateDefault returns the subject unless it is undefined or empty, in
+// which case the optional second child is returned.
+func (node Node) evaluateDefault(tmpl TemplateData) (any, error) {
+	if len(node.Children) != 1 && len(node.Children) != 2 {
+		return nil, fmt.Errorf("default node should have one or two children")
+	}
+
+	value, err := node.Children[0].evaluateExpression(tmpl)
+	if !isUndefined(value, err) {
+		if err != nil {
+			return nil, err
+		} else if !isEmpty(value) {
+			return value, nil
+		}
+	}
+
+	if len(node.Children) == 2 {
+		return node.Children[1].evaluateExpression(tmpl)
+	}
+	return "", nil
+}
+
+// evaluateTest applies the test named by the value to the first child. The
+// remaining children are the arguments of the test.
+func (node Node) evaluateTest(tmpl TemplateData) (any, error) {
+	if len(node.Children) == 0 {
+		return nil, fmt.Errorf("test node should have a subject")
+	}
+
+	value, err := node.Children[0].evaluateExpression(tmpl)
+	if node.Value == "defined" {
+		if isUndefined(value, err) {
+			return false, nil
+		}
+		return true, err
+	} else if err != nil {
+		return nil, err
+	}
+
+	args := make([]any, 0, len(node.Children)-1)
+	for _, cn := range node.Children[1:] {
+		arg, err := cn.evaluateExpression(tmpl)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+	}
+
+	switch node.Value {
+	case "null", "none":
+		_, isUndefinedValue := value.(Undefined)
+		return value == nil || isUndefinedValue, nil
+	case "empty":
+		return isEmpty(value), nil
+	case "iterable":
+		kind := reflect.ValueOf(value).Kind()
+		return kind == reflect.Slice || kind == reflect.Array || kind == reflect.Map, nil
+	case "even", "odd":
+		remainder, err := arithmetic("%", value, int64(2))
+		if err != nil {
+			return nil, err
+		}
+		return valuesEqual(remainder, int64(0)) == (node.Value == "even"), nil
+	case "divisibleby", "divisible by":
+		if len(args) != 1 {
+			return nil, fmt.Errorf("`%s` test expects one argument", node.Value)
+		}
+
+		remainder, err := arithmetic("%", value, args[0])
+		if err != nil {
+			return nil, err
+		}
+		return valuesEqual(remainder, int64(0)), nil
+	case "sameas", "same as":
+		if len(args) != 1 {
+			return nil, fmt.Errorf("`%s` test expects one argument", node.Value)
+		}
+		return valuesEqual(value, args[0]), nil
+	default:
+		return nil, fmt.Errorf("test `%s` does not exist", node.Value)
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestUndefinedPolicies(t *testing.T) {
+	testApp, err := newTwigApp(twigFile{"page.twig", "[{{ missing }}|{{ name.first ?? 'none' }}|{{ missing > 0 }}|{{ null < 1 }}]"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := map[string]any{"name": "Ned"}
+
+	cases := map[UndefinedPolicy]string{
+		UNDEFINED_LENIENT:   "[|none|false|true]",
+		UNDEFINED_CHAINABLE: "[|none|false|true]",
+		UNDEFINED_DEBUG:     "[{{ missing }}|none|false|true]",
+	}
+
+	for policy, expected := range cases {
+		output, err := testApp.RenderWithOptions("page", data, RenderOptions{Undefined: policy})
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", policy, err)
+		} else if output != expected {
+			t.Errorf("%s: expected %q, got %q", policy, expected, output)
+		}
+	}
+
+	if _, err := testApp.Render("page", data); err == nil || err.Error() != "variable `missing` does not exist" {
+		t.Errorf("expected the strict policy to fail, got %v", err)
+	}
+}
+
+func TestUndefinedPaths(t *testing.T) {
+	testApp, err := newTwigApp(
+		twigFile{"page.twig", "{{ user.profile.name }}"},
+		twigFile{"chain.twig", "[{{ user.profile.name }}]"},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := map[string]any{"user": map[string]any{}}
+	expected := "`profile` does not exist in `user.profile.name`"
+	if _, err := testApp.Render("page", data); err == nil || err.Error() != expected {
+		t.Errorf("expected %q, got %v", expected, err)
+	}
+
+	if output, err := testApp.RenderWithOptions("chain", data, RenderOptions{Undefined: UNDEFINED_CHAINABLE}); err != nil || output != "[]" {
+		t.Errorf("expected the chainable policy to render nothing, got %q, %v", output, err)
+	}
+
+	// the full path is reported even if the undefined error is wrapped
+	data["user"] = Lazy(func() (any, error) {
+		return nil, fmt.Errorf("loading user: %w", &UndefinedError{Path: "session", Name: "session"})
+	})
+	expected = "`session` does not exist in `user.profile.name`"
+	if _, err := testApp.Render("page", data); err == nil || err.Error() != expected {
+		t.Errorf("expected %q, got %v", expected, err)
+	}
+}
+
+func TestCompareUndefined(t *testing.T) {
+	cases := []struct {
+		left, right any
+		expected    int
+	}{
+		{nil, int64(1), -1},
+		{Undefined{}, int64(0), 0},
+		{int64(-1), nil, -1},
+		{nil, "a", -1},
+		{"", Undefined{}, 0},
+		{nil, nil, 0},
+	}
+
+	for _, c := range cases {
+		result, err := compareValues(c.left, c.right)
+		if err != nil {
+			t.Errorf("%#v <=> %#v: unexpected error: %s", c.left, c.right, err)
+		} else if result != c.expected {
+			t.Errorf("%#v <=> %#v: expected %d, got %d", c.left, c.right, c.expected, result)
+		}
+	}
+}